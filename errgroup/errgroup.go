@@ -2,27 +2,103 @@ package errgroup
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 // A Group is a collection of goroutines working on subtasks that are part of
 // the same overall task.
 //
-// A zero Group is valid and does not cancel on error.
+// A zero Group is valid and does not cancel on error. A Group can be reused
+// after Wait returns, but Go must not be called concurrently with Wait.
 type Group struct {
+	// accessed atomically, kept first for 64-bit alignment on 32-bit platforms
+	pending   int64
+	running   int64
+	succeeded int64
+	failed    int64
+	submitted int64 // functions accepted since the last Wait
+
 	err     error
 	wg      sync.WaitGroup
 	errOnce sync.Once
 
-	workerOnce sync.Once
-	ch         chan func(ctx context.Context) error
-	chs        []func(ctx context.Context) error
-	workerNum  int
+	procs int           // number of workers set by GOMAXPROCS
+	slots chan struct{} // one per running or queued function, limits Go to procs*2 in flight
+	ch    chan task     // started on demand, closed by Wait
+
+	parent  context.Context // context the group context is derived from
+	ctx     context.Context
+	cancel  func()
+	onPanic func(p *PanicError)
+
+	collectAll bool
+	mu         sync.Mutex
+	errs       Errors
+
+	submitMu sync.Mutex // serializes acceptance so that indexes follow acceptance order
+	next     int        // index of the next accepted function
+}
+
+// TaskError is the error returned by a single function passed to Go.
+type TaskError struct {
+	Index int    // the order in which the function was passed to Go, starting at 0
+	Label string // optional label identifying the task
+	Err   error
+}
+
+func (e *TaskError) Error() string {
+	return fmt.Sprintf("%s: %v", task{index: e.Index, label: e.Label}.name(), e.Err)
+}
+
+func (e *TaskError) Unwrap() error {
+	return e.Err
+}
+
+// Errors holds the errors of every failed task in a collect-all group,
+// ordered by task index. errors.Is and errors.As match any of them.
+type Errors []*TaskError
+
+func (e Errors) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("errgroup: %d tasks failed:", len(e)))
+	for _, err := range e {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Is reports whether any of the errors matches target. It lets errors.Is
+// look into every error before Go 1.20, which only follows a single Unwrap.
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the errors that matches target, see Is.
+func (e Errors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
 
-	ctx    context.Context
-	cancel func()
+func (e Errors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
 }
 
 // WithContext create a Group.
@@ -38,89 +114,249 @@ func WithContext(ctx context.Context) *Group {
 // returns a non-nil error or the first time Wait returns, whichever occurs
 // first.
 func WithCancel(ctx context.Context) *Group {
-	ctx, cancel := context.WithCancel(ctx)
-	return &Group{ctx: ctx, cancel: cancel}
+	g := &Group{parent: ctx}
+	g.ctx, g.cancel = context.WithCancel(ctx)
+	return g
+}
+
+// WithCollectAll create a Group that does not stop at the first error.
+//
+// given function from Go will receive this context, every function runs to
+// completion and Wait returns an Errors holding the error of each failed
+// function with its index, or nil if all of them succeeded.
+func WithCollectAll(ctx context.Context) *Group {
+	return &Group{ctx: ctx, collectAll: true}
+}
+
+// Stats is a snapshot of the functions passed to a Group.
+type Stats struct {
+	Pending   int64 // accepted and waiting for a worker
+	Running   int64 // running, including retries
+	Succeeded int64 // returned nil, since the Group was created
+	Failed    int64 // returned an error or panicked, since the Group was created
+}
+
+// Snapshot returns the current stats, it is safe to call at any time, for
+// example from a goroutine reporting progress.
+func (g *Group) Snapshot() Stats {
+	return Stats{
+		Pending:   atomic.LoadInt64(&g.pending),
+		Running:   atomic.LoadInt64(&g.running),
+		Succeeded: atomic.LoadInt64(&g.succeeded),
+		Failed:    atomic.LoadInt64(&g.failed),
+	}
 }
 
-func (g *Group) do(f func(ctx context.Context) error) {
+// OnPanic sets a function called with the recovered panic of every function
+// passed to Go, before the panic is reported as the function's error.
+// It must be set before calling Go.
+func (g *Group) OnPanic(fn func(p *PanicError)) {
+	g.onPanic = fn
+}
+
+func (g *Group) do(t task) {
 	ctx := g.ctx
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	var err error
+	atomic.AddInt64(&g.pending, -1)
+	atomic.AddInt64(&g.running, 1)
 	defer func() {
-		if r := recover(); r != nil {
-			buf := make([]byte, 64<<10)
-			buf = buf[:runtime.Stack(buf, false)]
-			err = fmt.Errorf("errgroup: panic recovered: %s\n%s", r, buf)
-		}
-		if err != nil {
-			g.errOnce.Do(func() {
-				g.err = err
-				if g.cancel != nil {
-					g.cancel()
-				}
-			})
+		atomic.AddInt64(&g.running, -1)
+		if t.slot {
+			<-g.slots
 		}
 		g.wg.Done()
 	}()
-	err = f(ctx)
+	err := t.run(ctx)
+	if err == nil {
+		atomic.AddInt64(&g.succeeded, 1)
+		return
+	}
+	atomic.AddInt64(&g.failed, 1)
+	if p, ok := err.(*PanicError); ok && g.onPanic != nil {
+		g.onPanic(p)
+	}
+	if g.collectAll {
+		g.mu.Lock()
+		g.errs = append(g.errs, &TaskError{Index: t.index, Label: t.label, Err: err})
+		g.mu.Unlock()
+		return
+	}
+	if _, ok := err.(*PanicError); !ok && t.label != "" {
+		err = &TaskError{Index: t.index, Label: t.label, Err: err}
+	}
+	g.errOnce.Do(func() {
+		g.err = err
+		if g.cancel != nil {
+			g.cancel()
+		}
+	})
 }
 
 // GOMAXPROCS set max goroutine to work.
+//
+// At most n functions run at the same time and at most n more wait in the
+// queue; once the queue is full Go blocks until a worker takes a function.
 func (g *Group) GOMAXPROCS(n int) {
 	if n <= 0 {
 		panic("errgroup: GOMAXPROCS must great than 0")
 	}
-	g.workerOnce.Do(func() {
-		g.ch = make(chan func(context.Context) error, n)
-		for i := 0; i < n; i++ {
-			go func() {
-				for f := range g.ch {
-					g.do(f)
-				}
-			}()
-		}
-	})
+	g.submitMu.Lock()
+	defer g.submitMu.Unlock()
+	if g.procs == 0 {
+		g.procs = n
+		g.slots = make(chan struct{}, 2*n)
+		g.start()
+	}
+}
+
+// start starts the workers, the caller must hold submitMu.
+func (g *Group) start() {
+	g.ch = make(chan task, cap(g.slots))
+	for i := 0; i < g.procs; i++ {
+		go func(ch chan task) {
+			for t := range ch {
+				g.do(t)
+			}
+		}(g.ch)
+	}
 }
 
-// Go calls the given function in a new goroutine.
+// Go calls the given function in a new goroutine, opts configure a label,
+// a timeout and retries for this function.
 //
 // The first call to return a non-nil error cancels the group; its error will be
-// returned by Wait.
-func (g *Group) Go(f func(ctx context.Context) error) {
-	g.wg.Add(1)
-	g.workerNum++
-	if g.ch != nil {
+// returned by Wait. In a collect-all group every error is kept instead.
+//
+// With GOMAXPROCS, Go blocks while all workers are busy and the queue is full,
+// so calling Go from a function already running in the same group may deadlock.
+func (g *Group) Go(f func(ctx context.Context) error, opts ...TaskOption) {
+	g.submit(nil, f, opts, true)
+}
+
+// GoCtx is like Go but stops waiting for a free worker when ctx or the
+// group's context is done, in which case f is not run and the context error
+// is returned.
+func (g *Group) GoCtx(ctx context.Context, f func(ctx context.Context) error, opts ...TaskOption) error {
+	if !g.submit(ctx, f, opts, true) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return g.ctx.Err()
+	}
+	return nil
+}
+
+// TryGo calls the given function in a new goroutine only if a worker or a
+// queue slot is free, and reports whether the function was accepted.
+// Without GOMAXPROCS it always accepts the function.
+func (g *Group) TryGo(f func(ctx context.Context) error, opts ...TaskOption) bool {
+	return g.submit(nil, f, opts, false)
+}
+
+// submit hands f to a worker, waiting for a free slot if block is set until
+// ctx (when not nil) or the group's context is done. It reports whether f was accepted.
+//
+// Waiting happens on the slots semaphore without holding submitMu, so TryGo
+// and GoCtx from other goroutines are not held up by a blocked Go.
+func (g *Group) submit(ctx context.Context, f func(ctx context.Context) error, opts []TaskOption, block bool) bool {
+	if ctx != nil && ctx.Err() != nil {
+		return false
+	}
+	g.submitMu.Lock()
+	slots, groupCtx := g.slots, g.ctx
+	g.submitMu.Unlock()
+	if slots != nil {
 		select {
-		case g.ch <- f:
+		case slots <- struct{}{}:
 		default:
-			g.chs = append(g.chs, f)
+			if !block {
+				return false
+			}
+			// Go waits unconditionally, GoCtx also gives up when either context is done
+			var done, groupDone <-chan struct{}
+			if ctx != nil {
+				done = ctx.Done()
+				if groupCtx != nil {
+					groupDone = groupCtx.Done()
+				}
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return false
+			case <-groupDone:
+				return false
+			}
 		}
-		return
 	}
-	go g.do(f)
+
+	// the index is reserved only once the function is accepted
+	g.submitMu.Lock()
+	defer g.submitMu.Unlock()
+	t := newTask(g.next, f, opts)
+	t.slot = slots != nil
+	// count the task before a worker can finish it
+	g.wg.Add(1)
+	atomic.AddInt64(&g.pending, 1)
+	atomic.AddInt64(&g.submitted, 1)
+	g.next++
+	if !t.slot {
+		go g.do(t)
+		return true
+	}
+	if g.ch == nil {
+		// workers of a reused group were stopped by Wait
+		g.start()
+	}
+	// never blocks: the queue has room for every slot
+	g.ch <- t
+	return true
 }
 
 // Wait blocks until all function calls from the Go method have returned, then
-// returns the first non-nil error (if any) from them.
+// returns the first non-nil error (if any) from them, or an Errors holding
+// every error in a collect-all group.
+//
+// Wait stops the workers and resets the errors, so the Group can be used
+// again for another set of functions; the workers are started again by the
+// next call to Go, and a group created by WithCancel gets a new context.
 func (g *Group) Wait() error {
-	if g.ch != nil {
-		for _, f := range g.chs {
-			g.ch <- f
-		}
-	}
 	g.wg.Wait()
+	g.submitMu.Lock()
+	defer g.submitMu.Unlock()
 	if g.ch != nil {
 		close(g.ch) // let all receiver exit
+		g.ch = nil
 	}
 	if g.cancel != nil {
 		g.cancel()
 	}
-	g.workerNum = 0
-	return g.err
+	err := g.err
+	if g.collectAll {
+		err = nil
+		g.mu.Lock()
+		if len(g.errs) > 0 {
+			errs := append(Errors(nil), g.errs...)
+			sort.Slice(errs, func(i, j int) bool { return errs[i].Index < errs[j].Index })
+			err = errs
+		}
+		g.errs = nil
+		g.mu.Unlock()
+	}
+
+	g.err = nil
+	g.errOnce = sync.Once{}
+	g.next = 0
+	atomic.StoreInt64(&g.submitted, 0)
+	if g.cancel != nil {
+		g.ctx, g.cancel = context.WithCancel(g.parent)
+	}
+	return err
 }
 
+// WorkNum returns the number of functions accepted since the last Wait.
 func (g *Group) WorkNum() int {
-	return g.workerNum
+	return int(atomic.LoadInt64(&g.submitted))
 }
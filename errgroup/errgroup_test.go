@@ -3,6 +3,9 @@ package errgroup
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -62,3 +65,346 @@ func TestErrgroup1(t *testing.T) {
 		}
 	}
 }
+
+func TestCollectAll(t *testing.T) {
+	errNotFound := errors.New("not found")
+	eg := WithCollectAll(context.Background())
+	eg.GOMAXPROCS(2)
+	var done int64
+	for i := 0; i < 10; i++ {
+		i := i
+		eg.Go(func(ctx context.Context) error {
+			atomic.AddInt64(&done, 1)
+			switch i {
+			case 3:
+				return errNotFound
+			case 5:
+				panic("boom")
+			case 8:
+				return fmt.Errorf("lookup: %w", &net.DNSError{Err: "timeout"})
+			}
+			return nil
+		})
+	}
+	err := eg.Wait()
+	if done != 10 {
+		t.Fatal(done)
+	}
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatal(err)
+	}
+	if errs[0].Index != 3 || errs[1].Index != 5 || errs[2].Index != 8 {
+		t.Fatal(err)
+	}
+	if !errors.Is(err, errNotFound) || !strings.Contains(errs[1].Error(), "panic recovered") {
+		t.Fatal(err)
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || dnsErr.Err != "timeout" {
+		t.Fatal(err)
+	}
+	// matched by the methods themselves, without Unwrap() []error
+	dnsErr = nil
+	if !errs.Is(errNotFound) || !errs.As(&dnsErr) || dnsErr.Err != "timeout" || errs.Is(context.Canceled) {
+		t.Fatal(err)
+	}
+
+	eg = WithCollectAll(context.Background())
+	eg.Go(func(ctx context.Context) error { return nil })
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	var eg Group
+	eg.GOMAXPROCS(2)
+	release := make(chan struct{})
+	var running, maxRunning int64
+	block := func(ctx context.Context) error {
+		n := atomic.AddInt64(&running, 1)
+		for {
+			m := atomic.LoadInt64(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt64(&maxRunning, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt64(&running, -1)
+		return nil
+	}
+
+	// 2 running and 2 queued, then the group is full
+	eg.Go(block)
+	eg.Go(block)
+	for atomic.LoadInt64(&running) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if !eg.TryGo(block) || !eg.TryGo(block) || eg.TryGo(block) {
+		t.Fatal("want 2 queued functions")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := eg.GoCtx(ctx, block); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal(err)
+	}
+
+	submitted := make(chan struct{})
+	go func() {
+		eg.Go(block)
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+		t.Fatal("Go should block while the group is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-submitted
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if maxRunning > 2 {
+		t.Fatal(maxRunning)
+	}
+}
+
+func TestGoCtxGroupCancelled(t *testing.T) {
+	eg := WithCancel(context.Background())
+	eg.GOMAXPROCS(1)
+	release := make(chan struct{})
+	eg.Go(func(ctx context.Context) error {
+		<-release
+		return errors.New("fail")
+	})
+	eg.Go(func(ctx context.Context) error { return nil })
+	errc := make(chan error, 1)
+	go func() {
+		errc <- eg.GoCtx(context.Background(), func(ctx context.Context) error { return nil })
+	}()
+	close(release)
+	// the queued function may take the slot before or after the failure
+	if err := <-errc; err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	if err := eg.Wait(); err == nil || err.Error() != "fail" {
+		t.Fatal(err)
+	}
+}
+
+func TestSubmitWhileBlocked(t *testing.T) {
+	var eg Group
+	eg.GOMAXPROCS(1)
+	release := make(chan struct{})
+	block := func(ctx context.Context) error {
+		<-release
+		return nil
+	}
+	eg.Go(block)
+	eg.Go(block)
+	blocked := make(chan struct{})
+	go func() {
+		eg.Go(block)
+		close(blocked)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// neither waits for the blocked Go
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if eg.TryGo(block) {
+			t.Error("TryGo accepted a function while the group is full")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := eg.GoCtx(ctx, block); !errors.Is(err, context.DeadlineExceeded) {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("TryGo and GoCtx are held up by a blocked Go")
+	}
+	close(release)
+	<-blocked
+	if err := eg.Wait(); err != nil || eg.WorkNum() != 0 {
+		t.Fatal(err)
+	}
+}
+
+func TestMap(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	var running, maxRunning int64
+	results, err := Map(context.Background(), items, 3, func(ctx context.Context, n int) (string, error) {
+		cur := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+		for {
+			m := atomic.LoadInt64(&maxRunning)
+			if cur <= m || atomic.CompareAndSwapInt64(&maxRunning, m, cur) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(10-n) * time.Millisecond)
+		return strings.Repeat("x", n), nil
+	})
+	if err != nil || maxRunning > 3 {
+		t.Fatal(err, maxRunning)
+	}
+	for i, r := range results {
+		if len(r) != items[i] {
+			t.Fatal(results)
+		}
+	}
+
+	errOdd := errors.New("odd")
+	var calls int64
+	_, err = Map(context.Background(), items, 1, func(ctx context.Context, n int) (int, error) {
+		atomic.AddInt64(&calls, 1)
+		if n == 3 {
+			return 0, errOdd
+		}
+		return n, nil
+	})
+	if err != errOdd || calls >= int64(len(items)) {
+		t.Fatal(err, calls)
+	}
+
+	results2, err := MapAll(context.Background(), items, 2, func(ctx context.Context, n int) (int, error) {
+		if n%2 == 1 {
+			return 0, errOdd
+		}
+		return n * 10, nil
+	})
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 4 || errs[0].Index != 0 || errs[3].Index != 6 || !errors.Is(err, errOdd) {
+		t.Fatal(err)
+	}
+	if results2[1] != 20 || results2[0] != 0 {
+		t.Fatal(results2)
+	}
+}
+
+func TestMapCancel(t *testing.T) {
+	items := make([]int, 50)
+	for _, mapFunc := range []func(context.Context, []int, int, func(context.Context, int) (int, error)) ([]int, error){Map[int, int], MapAll[int, int]} {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		// the calls ignore the cancellation and succeed
+		results, err := mapFunc(ctx, items, 1, func(ctx context.Context, n int) (int, error) {
+			<-ctx.Done()
+			return 1, nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatal(err)
+		}
+		if results[len(results)-1] != 0 {
+			t.Fatal(results)
+		}
+	}
+}
+
+func TestTaskOptions(t *testing.T) {
+	errTemporary := errors.New("temporary")
+	var eg Group
+	var attempts int64
+	eg.Go(func(ctx context.Context) error {
+		if atomic.AddInt64(&attempts, 1) < 3 {
+			return errTemporary
+		}
+		return nil
+	}, WithRetry(3, time.Millisecond))
+	if err := eg.Wait(); err != nil || attempts != 3 {
+		t.Fatal(err, attempts)
+	}
+
+	errPermanent := errors.New("permanent")
+	attempts = 0
+	eg = Group{}
+	eg.Go(func(ctx context.Context) error {
+		atomic.AddInt64(&attempts, 1)
+		return errPermanent
+	}, WithRetry(3, 0), WithRetryIf(func(err error) bool { return err != errPermanent }), WithLabel("user:42"))
+	err := eg.Wait()
+	var taskErr *TaskError
+	if !errors.As(err, &taskErr) || taskErr.Label != "user:42" || !errors.Is(err, errPermanent) || attempts != 1 {
+		t.Fatal(err, attempts)
+	}
+	if err.Error() != "task 0 (user:42): permanent" {
+		t.Fatal(err)
+	}
+
+	eg = Group{}
+	attempts = 0
+	eg.Go(func(ctx context.Context) error {
+		atomic.AddInt64(&attempts, 1)
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithTimeout(10*time.Millisecond), WithRetry(1, 0))
+	if err := eg.Wait(); !errors.Is(err, context.DeadlineExceeded) || attempts != 2 {
+		t.Fatal(err, attempts)
+	}
+
+	eg = Group{}
+	attempts = 0
+	eg.Go(func(ctx context.Context) error {
+		atomic.AddInt64(&attempts, 1)
+		panic("boom")
+	}, WithLabel("import"), WithRetry(3, 0))
+	if err := eg.Wait(); err == nil || !strings.Contains(err.Error(), "panic recovered in task 0 (import): boom") || attempts != 1 {
+		t.Fatal(err, attempts)
+	}
+}
+
+func TestReuse(t *testing.T) {
+	eg := WithCancel(context.Background())
+	eg.GOMAXPROCS(2)
+	var panics []*PanicError
+	eg.OnPanic(func(p *PanicError) {
+		panics = append(panics, p)
+	})
+
+	release := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		eg.Go(func(ctx context.Context) error {
+			<-release
+			return nil
+		})
+	}
+	if s := eg.Snapshot(); s.Running+s.Pending != 4 || eg.WorkNum() != 4 {
+		t.Fatalf("%+v", s)
+	}
+	close(release)
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if s := eg.Snapshot(); s != (Stats{Succeeded: 4}) || eg.WorkNum() != 0 {
+		t.Fatalf("%+v", s)
+	}
+
+	// the group is cancelled by the failure, then reused with a new context
+	eg.Go(func(ctx context.Context) error {
+		panic(errors.New("boom"))
+	}, WithLabel("parse"))
+	err := eg.Wait()
+	var p *PanicError
+	if !errors.As(err, &p) || p.Label != "parse" || p.Index != 0 || len(p.Stack) == 0 || err.Error() == "" {
+		t.Fatal(err)
+	}
+	if len(panics) != 1 || panics[0] != p || p.Unwrap() == nil || p.Unwrap().Error() != "boom" {
+		t.Fatal(panics)
+	}
+
+	eg.Go(func(ctx context.Context) error {
+		return ctx.Err()
+	})
+	if err := eg.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if s := eg.Snapshot(); s.Succeeded != 5 || s.Failed != 1 || s.Running != 0 || s.Pending != 0 {
+		t.Fatalf("%+v", s)
+	}
+}
@@ -0,0 +1,49 @@
+package errgroup
+
+import "context"
+
+// Map calls f for every item with at most limit calls running at the same
+// time (no limit if limit <= 0) and returns the results in input order.
+//
+// The first error cancels the context passed to the other calls and stops
+// starting new ones; Map then returns the results collected so far together
+// with that error. If ctx is done before every item was started, the context
+// error is returned even when no call failed.
+func Map[T, R any](ctx context.Context, items []T, limit int, f func(ctx context.Context, item T) (R, error)) ([]R, error) {
+	return mapItems(WithCancel(ctx), items, limit, f)
+}
+
+// MapAll is like Map but calls f for every item even if some of them fail.
+// The returned error is an Errors whose indexes are the indexes of the failed
+// items, and the results of the failed items are zero values.
+func MapAll[T, R any](ctx context.Context, items []T, limit int, f func(ctx context.Context, item T) (R, error)) ([]R, error) {
+	return mapItems(WithCollectAll(ctx), items, limit, f)
+}
+
+func mapItems[T, R any](g *Group, items []T, limit int, f func(ctx context.Context, item T) (R, error)) ([]R, error) {
+	results := make([]R, len(items))
+	if limit > 0 {
+		g.GOMAXPROCS(limit)
+	}
+	var submitErr error
+	for i := range items {
+		i := i
+		submitErr = g.GoCtx(g.ctx, func(ctx context.Context) error {
+			r, err := f(ctx, items[i])
+			if err != nil {
+				return err
+			}
+			results[i] = r
+			return nil
+		})
+		if submitErr != nil {
+			// the group was cancelled by a failed call or by the caller
+			break
+		}
+	}
+	if err := g.Wait(); err != nil {
+		return results, err
+	}
+	// no call failed but not every item was submitted
+	return results, submitErr
+}
@@ -0,0 +1,137 @@
+package errgroup
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// TaskOption configures a single function passed to Go, GoCtx or TryGo.
+type TaskOption func(t *task)
+
+// WithLabel labels the function so that its error and panic identify it.
+func WithLabel(label string) TaskOption {
+	return func(t *task) {
+		t.label = label
+	}
+}
+
+// WithTimeout runs each attempt of the function with a child context that
+// is cancelled after d.
+func WithTimeout(d time.Duration) TaskOption {
+	return func(t *task) {
+		t.timeout = d
+	}
+}
+
+// WithRetry calls the function again up to retries times while it returns
+// an error, waiting backoff before the first retry and doubling the wait
+// before each following one. Panics are not retried, and retrying stops when
+// the group's context is done.
+func WithRetry(retries int, backoff time.Duration) TaskOption {
+	return func(t *task) {
+		t.retries = retries
+		t.backoff = backoff
+	}
+}
+
+// WithRetryIf only retries errors for which retryable returns true, for
+// example to skip errors that will not go away. By default every error is
+// retried.
+func WithRetryIf(retryable func(err error) bool) TaskOption {
+	return func(t *task) {
+		t.retryable = retryable
+	}
+}
+
+// task is a function passed to Go with its index and options.
+type task struct {
+	index int
+	f     func(ctx context.Context) error
+	slot  bool // holds a slot of the group, released when f returns
+
+	label     string
+	timeout   time.Duration
+	retries   int
+	backoff   time.Duration
+	retryable func(err error) bool
+}
+
+func newTask(index int, f func(ctx context.Context) error, opts []TaskOption) task {
+	t := task{index: index, f: f}
+	for _, opt := range opts {
+		opt(&t)
+	}
+	return t
+}
+
+// PanicError is the error of a function that panicked.
+type PanicError struct {
+	Index int    // index of the function, see TaskError
+	Label string // label of the function, if any
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("errgroup: panic recovered in %s: %v\n%s", task{index: e.Index, label: e.Label}.name(), e.Value, e.Stack)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// run calls the function, retrying it as configured.
+func (t task) run(ctx context.Context) error {
+	backoff := t.backoff
+	for attempt := 0; ; attempt++ {
+		err := t.call(ctx)
+		if _, panicked := err.(*PanicError); panicked {
+			return err
+		}
+		if err == nil || attempt >= t.retries || ctx.Err() != nil {
+			return err
+		}
+		if t.retryable != nil && !t.retryable(err) {
+			return err
+		}
+		if backoff > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			}
+			backoff *= 2
+		}
+	}
+}
+
+// call calls the function once, converting a panic into a *PanicError.
+func (t task) call(ctx context.Context) (err error) {
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			err = &PanicError{Index: t.index, Label: t.label, Value: r, Stack: buf}
+		}
+	}()
+	return t.f(ctx)
+}
+
+// name identifies the task in errors.
+func (t task) name() string {
+	if t.label != "" {
+		return fmt.Sprintf("task %d (%s)", t.index, t.label)
+	}
+	return fmt.Sprintf("task %d", t.index)
+}
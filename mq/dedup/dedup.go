@@ -0,0 +1,152 @@
+// Package dedup 消费端幂等去重
+//
+// RabbitMQ 至少投递一次，断线重连、处理超时等情况下同一条消息可能被投递多次。
+// 去重中间件按消息ID（或自定义的键）记录已处理的消息，重复的消息直接确认而不再执行 handler。
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yiuked/gopkg/mq"
+	"github.com/yiuked/gopkg/mq/rabbit"
+)
+
+// State 消息的处理状态
+type State int
+
+const (
+	StateNew        State = iota // 未处理过，已被当前调用方占用
+	StateProcessing              // 其它消费者正在处理
+	StateDone                    // 已处理完成
+)
+
+// ErrInProgress 相同的消息正在被其它消费者处理
+// 中间件返回该错误，errors.Is(ErrInProgress, rabbit.ErrRetryLater) 成立，消息经第一个重试延迟后重新投递且不计入重试次数，
+// 届时若已处理完成则直接确认，不会因为另一个消费者处理时间较长而进入死信队列。
+// 通过 Handler 用于 mq.Subscriber 时按普通错误重试，重试的总等待时间应大于 ProcessingTTL。
+var ErrInProgress error = inProgressError{}
+
+type inProgressError struct{}
+
+func (inProgressError) Error() string {
+	return "dedup: message is being processed"
+}
+
+func (inProgressError) Is(target error) bool {
+	return target == rabbit.ErrRetryLater
+}
+
+// Store 已处理消息的记录，需要支持多个消费者并发访问
+type Store interface {
+	// Acquire 原子地检查 key 的状态，未处理过（或记录已过期）时标记为处理中并返回 StateNew，
+	// 处理中的标记在 ttl 后过期，避免消费者崩溃后消息永远无法处理
+	Acquire(ctx context.Context, key string, ttl time.Duration) (State, error)
+	// Complete 标记 key 已处理完成，记录保留 ttl
+	Complete(ctx context.Context, key string, ttl time.Duration) error
+	// Release 删除处理中的标记，处理失败后调用，允许重新投递的消息再次处理
+	Release(ctx context.Context, key string) error
+}
+
+// Option 去重配置
+type Option struct {
+	KeyFunc       func(msg *rabbit.Message) string // 去重键，默认使用 MessageId，返回空字符串时不去重
+	Prefix        string                           // 键前缀，多个队列共用存储时用于区分
+	TTL           time.Duration                    // 已处理记录的保留时间，默认24小时，应大于消息可能被重复投递的时间窗口
+	ProcessingTTL time.Duration                    // 处理中标记的过期时间，默认5分钟，应大于 handler 的最长执行时间
+}
+
+// Deduper 去重器
+type Deduper struct {
+	store Store
+	opts  Option
+}
+
+// New 创建去重器，option 可为 nil
+func New(store Store, option *Option) *Deduper {
+	d := &Deduper{store: store}
+	if option != nil {
+		d.opts = *option
+	}
+	if d.opts.TTL <= 0 {
+		d.opts.TTL = 24 * time.Hour
+	}
+	if d.opts.ProcessingTTL <= 0 {
+		d.opts.ProcessingTTL = 5 * time.Minute
+	}
+	return d
+}
+
+// Do 以 key 去重执行 fn，key 已处理完成时不执行并返回 nil，正在处理时返回 ErrInProgress
+// 存储不可用时不去重，直接执行 fn。
+func (d *Deduper) Do(ctx context.Context, key string, fn func() error) error {
+	if key == "" {
+		return fn()
+	}
+	key = d.opts.Prefix + key
+	state, err := d.store.Acquire(ctx, key, d.opts.ProcessingTTL)
+	if err != nil {
+		log.Println("dedup: acquire err,", key, err.Error())
+		return fn()
+	}
+	switch state {
+	case StateDone:
+		return nil
+	case StateProcessing:
+		return ErrInProgress
+	}
+
+	if err := fn(); err != nil {
+		if rerr := d.store.Release(ctx, key); rerr != nil {
+			log.Println("dedup: release err,", key, rerr.Error())
+		}
+		return err
+	}
+	if err := d.store.Complete(ctx, key, d.opts.TTL); err != nil {
+		log.Println("dedup: complete err,", key, err.Error())
+	}
+	return nil
+}
+
+// Middleware 返回 rabbit 消费端去重中间件，配合 rabbit.WithMiddleware 使用
+// handler 中 panic 时同样释放处理中的标记。
+func (d *Deduper) Middleware() rabbit.Middleware {
+	keyFunc := d.opts.KeyFunc
+	if keyFunc == nil {
+		keyFunc = func(msg *rabbit.Message) string {
+			return msg.MessageId
+		}
+	}
+	return func(next rabbit.Handler) rabbit.Handler {
+		return func(ctx context.Context, msg *rabbit.Message) error {
+			return d.Do(ctx, keyFunc(msg), func() error {
+				return safe(func() error {
+					return next(ctx, msg)
+				})
+			})
+		}
+	}
+}
+
+// Handler 包装 mq.Handler，以消息ID去重，用于 mq.Subscriber（如 memory.Broker）
+func (d *Deduper) Handler(next mq.Handler) mq.Handler {
+	return func(ctx context.Context, msg *mq.Message) error {
+		return d.Do(ctx, msg.ID, func() error {
+			return safe(func() error {
+				return next(ctx, msg)
+			})
+		})
+	}
+}
+
+// safe 执行 fn，panic 时转换为错误，保证处理中的标记被释放
+func safe(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("dedup: panic recovered: %v", r)
+		}
+	}()
+	return fn()
+}
@@ -0,0 +1,192 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/yiuked/gopkg/mq/rabbit"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(2)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	if state, _ := s.Acquire(ctx, "a", time.Minute); state != StateNew {
+		t.Fatal(state)
+	}
+	if state, _ := s.Acquire(ctx, "a", time.Minute); state != StateProcessing {
+		t.Fatal(state)
+	}
+	_ = s.Complete(ctx, "a", time.Hour)
+	if state, _ := s.Acquire(ctx, "a", time.Minute); state != StateDone {
+		t.Fatal(state)
+	}
+	// 已完成的记录不会被 Release 删除
+	_ = s.Release(ctx, "a")
+	if state, _ := s.Acquire(ctx, "a", time.Minute); state != StateDone {
+		t.Fatal(state)
+	}
+
+	// 处理中的标记过期后可以被接管
+	_, _ = s.Acquire(ctx, "b", time.Minute)
+	now = now.Add(2 * time.Minute)
+	if state, _ := s.Acquire(ctx, "b", time.Minute); state != StateNew {
+		t.Fatal(state)
+	}
+
+	// 超出容量淘汰最久未使用的记录
+	_, _ = s.Acquire(ctx, "c", time.Minute)
+	if s.Len() != 2 {
+		t.Fatal(s.Len())
+	}
+	if state, _ := s.Acquire(ctx, "a", time.Minute); state != StateNew {
+		t.Fatal(state)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	d := New(NewMemoryStore(0), &Option{Prefix: "order:"})
+	ctx := context.Background()
+
+	var calls int
+	fail := true
+	handler := d.Middleware()(func(ctx context.Context, msg *rabbit.Message) error {
+		calls++
+		if fail {
+			return errors.New("fail")
+		}
+		return nil
+	})
+	msg := func(id string) *rabbit.Message {
+		return &rabbit.Message{Delivery: amqp.Delivery{MessageId: id}}
+	}
+
+	// 失败后释放标记，重新投递时再次处理
+	if err := handler(ctx, msg("1")); err == nil {
+		t.Fatal("want error")
+	}
+	fail = false
+	if err := handler(ctx, msg("1")); err != nil {
+		t.Fatal(err)
+	}
+	// 重复消息直接确认
+	if err := handler(ctx, msg("1")); err != nil || calls != 2 {
+		t.Fatal(err, calls)
+	}
+	// 没有消息ID时不去重
+	_ = handler(ctx, msg(""))
+	_ = handler(ctx, msg(""))
+	if calls != 4 {
+		t.Fatal(calls)
+	}
+}
+
+func TestMiddlewareInProgress(t *testing.T) {
+	d := New(NewMemoryStore(0), nil)
+	ctx := context.Background()
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := d.Middleware()(func(ctx context.Context, msg *rabbit.Message) error {
+		close(started)
+		<-release
+		return nil
+	})
+	msg := &rabbit.Message{Delivery: amqp.Delivery{MessageId: "1"}}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := handler(ctx, msg); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-started
+	if err := handler(ctx, msg); !errors.Is(err, ErrInProgress) || !errors.Is(err, rabbit.ErrRetryLater) {
+		t.Fatal(err)
+	}
+	close(release)
+	wg.Wait()
+	if err := handler(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	store := NewMemoryStore(0)
+	handler := New(store, nil).Middleware()(func(ctx context.Context, msg *rabbit.Message) error {
+		panic("boom")
+	})
+	if err := handler(context.Background(), &rabbit.Message{Delivery: amqp.Delivery{MessageId: "1"}}); err == nil {
+		t.Fatal("want error")
+	}
+	if store.Len() != 0 {
+		t.Fatal(store.Len())
+	}
+}
+
+type fakeRedis struct {
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func (r *fakeRedis) SetNX(_ context.Context, key, value string, _ time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.data[key]; ok {
+		return false, nil
+	}
+	r.data[key] = value
+	return true, nil
+}
+
+func (r *fakeRedis) Get(_ context.Context, key string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.data[key], nil
+}
+
+func (r *fakeRedis) Set(_ context.Context, key, value string, _ time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.data[key] = value
+	return nil
+}
+
+func (r *fakeRedis) DelIfEqual(_ context.Context, key, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.data[key] == value {
+		delete(r.data, key)
+	}
+	return nil
+}
+
+func TestRedisStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewRedisStore(&fakeRedis{data: map[string]string{}})
+	if state, _ := s.Acquire(ctx, "a", time.Minute); state != StateNew {
+		t.Fatal(state)
+	}
+	if state, _ := s.Acquire(ctx, "a", time.Minute); state != StateProcessing {
+		t.Fatal(state)
+	}
+	_ = s.Release(ctx, "a")
+	if state, _ := s.Acquire(ctx, "a", time.Minute); state != StateNew {
+		t.Fatal(state)
+	}
+	_ = s.Complete(ctx, "a", time.Hour)
+	if state, _ := s.Acquire(ctx, "a", time.Minute); state != StateDone {
+		t.Fatal(state)
+	}
+	// 已处理完成的记录不会被释放
+	_ = s.Release(ctx, "a")
+	if state, _ := s.Acquire(ctx, "a", time.Minute); state != StateDone {
+		t.Fatal(state)
+	}
+}
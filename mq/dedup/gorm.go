@@ -0,0 +1,92 @@
+package dedup
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Record 去重记录
+type Record struct {
+	Key       string    `gorm:"primaryKey;size:255"`
+	State     int8      `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	UpdatedAt time.Time `gorm:"not null"`
+}
+
+func (Record) TableName() string {
+	return "mq_dedup"
+}
+
+// GormStore 数据库存储，多实例共享，依赖主键唯一约束保证并发安全
+type GormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore 创建数据库存储，表结构见 Record，可通过 AutoMigrate 创建
+func NewGormStore(db *gorm.DB) *GormStore {
+	return &GormStore{db: db}
+}
+
+// AutoMigrate 创建或更新去重表
+func (s *GormStore) AutoMigrate() error {
+	return s.db.AutoMigrate(&Record{})
+}
+
+func (s *GormStore) Acquire(ctx context.Context, key string, ttl time.Duration) (State, error) {
+	db := s.db.WithContext(ctx)
+	now := time.Now()
+	record := Record{Key: key, State: int8(StateProcessing), ExpiresAt: now.Add(ttl), UpdatedAt: now}
+
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if res.Error != nil {
+		return StateNew, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return StateNew, nil
+	}
+
+	// 记录已存在，过期时接管（处理中的消费者崩溃或已处理记录超过保留时间）
+	res = db.Model(&Record{}).
+		Where(clause.Eq{Column: "key", Value: key}).Where("expires_at <= ?", now).
+		Updates(map[string]interface{}{"state": int8(StateProcessing), "expires_at": now.Add(ttl), "updated_at": now})
+	if res.Error != nil {
+		return StateNew, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return StateNew, nil
+	}
+
+	var existing Record
+	if err := db.Where(clause.Eq{Column: "key", Value: key}).Take(&existing).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			// 在两次查询之间被释放，按处理中返回，由重试重新投递
+			return StateProcessing, nil
+		}
+		return StateNew, err
+	}
+	return State(existing.State), nil
+}
+
+func (s *GormStore) Complete(ctx context.Context, key string, ttl time.Duration) error {
+	now := time.Now()
+	record := Record{Key: key, State: int8(StateDone), ExpiresAt: now.Add(ttl), UpdatedAt: now}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"state", "expires_at", "updated_at"}),
+	}).Create(&record).Error
+}
+
+func (s *GormStore) Release(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).
+		Where(clause.Eq{Column: "key", Value: key}).Where("state = ?", int8(StateProcessing)).
+		Delete(&Record{}).Error
+}
+
+// Cleanup 删除已过期的记录，返回删除的条数，可定时调用
+func (s *GormStore) Cleanup(ctx context.Context) (int64, error) {
+	res := s.db.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&Record{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,99 @@
+package dedup
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore 进程内的 LRU 存储，只能对同一进程内的消费者去重
+type MemoryStore struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+type memoryEntry struct {
+	key     string
+	state   State
+	expires time.Time
+}
+
+// NewMemoryStore 创建进程内存储，最多保留 size 条记录，超出时淘汰最久未使用的记录，size<=0 时默认10000
+func NewMemoryStore(size int) *MemoryStore {
+	if size <= 0 {
+		size = 10000
+	}
+	return &MemoryStore{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+		now:   time.Now,
+	}
+}
+
+func (s *MemoryStore) Acquire(_ context.Context, key string, ttl time.Duration) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*memoryEntry)
+		if now.Before(e.expires) {
+			s.ll.MoveToFront(el)
+			return e.state, nil
+		}
+		e.state, e.expires = StateProcessing, now.Add(ttl)
+		s.ll.MoveToFront(el)
+		return StateNew, nil
+	}
+
+	s.items[key] = s.ll.PushFront(&memoryEntry{key: key, state: StateProcessing, expires: now.Add(ttl)})
+	for s.ll.Len() > s.size {
+		s.remove(s.ll.Back())
+	}
+	return StateNew, nil
+}
+
+func (s *MemoryStore) Complete(_ context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := s.now().Add(ttl)
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*memoryEntry)
+		e.state, e.expires = StateDone, expires
+		s.ll.MoveToFront(el)
+		return nil
+	}
+	s.items[key] = s.ll.PushFront(&memoryEntry{key: key, state: StateDone, expires: expires})
+	for s.ll.Len() > s.size {
+		s.remove(s.ll.Back())
+	}
+	return nil
+}
+
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok && el.Value.(*memoryEntry).state == StateProcessing {
+		s.remove(el)
+	}
+	return nil
+}
+
+// Len 当前保留的记录数（包含已过期未淘汰的记录）
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ll.Len()
+}
+
+func (s *MemoryStore) remove(el *list.Element) {
+	s.ll.Remove(el)
+	delete(s.items, el.Value.(*memoryEntry).key)
+}
@@ -0,0 +1,70 @@
+package dedup
+
+import (
+	"context"
+	"time"
+)
+
+// 保存在 Redis 中的状态值
+const (
+	redisProcessing = "processing"
+	redisDone       = "done"
+)
+
+// RedisClient Redis 兼容客户端需要实现的方法，可用 go-redis 等客户端适配
+type RedisClient interface {
+	// SetNX key 不存在时设置并返回 true
+	SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error)
+	// Get 获取 key 的值，key 不存在时返回空字符串
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// DelIfEqual key 的值等于 value 时删除，需要原子执行，如执行 Lua 脚本：
+	//	if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0
+	DelIfEqual(ctx context.Context, key, value string) error
+}
+
+// RedisStore Redis 存储，多实例共享，记录的过期由 Redis 负责
+type RedisStore struct {
+	client RedisClient
+}
+
+// NewRedisStore 创建 Redis 存储
+func NewRedisStore(client RedisClient) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Acquire(ctx context.Context, key string, ttl time.Duration) (State, error) {
+	ok, err := s.client.SetNX(ctx, key, redisProcessing, ttl)
+	if err != nil {
+		return StateNew, err
+	}
+	if ok {
+		return StateNew, nil
+	}
+
+	value, err := s.client.Get(ctx, key)
+	if err != nil {
+		return StateNew, err
+	}
+	switch value {
+	case redisDone:
+		return StateDone, nil
+	case "":
+		// 在两次调用之间过期或被释放，再尝试一次
+		if ok, err = s.client.SetNX(ctx, key, redisProcessing, ttl); err != nil {
+			return StateNew, err
+		} else if ok {
+			return StateNew, nil
+		}
+	}
+	return StateProcessing, nil
+}
+
+func (s *RedisStore) Complete(ctx context.Context, key string, ttl time.Duration) error {
+	return s.client.Set(ctx, key, redisDone, ttl)
+}
+
+// Release 只删除处理中的标记，不会删除已处理完成的记录
+func (s *RedisStore) Release(ctx context.Context, key string) error {
+	return s.client.DelIfEqual(ctx, key, redisProcessing)
+}
@@ -0,0 +1,308 @@
+// Package memory 内存消息中间件，实现 mq.Broker，与 rabbit.Client 保持相同的语义，用于单元测试与本地开发
+//
+// 支持竞争消费、处理失败重新投递、消息过期与死信队列，所有数据只保存在进程内存中。
+package memory
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/yiuked/gopkg/mq"
+)
+
+const (
+	// DefaultMaxRetries 默认最大重试次数，与 rabbit 一致
+	DefaultMaxRetries = 3
+
+	HeaderDeathQueue  = "x-first-death-queue"  // 死信消息原所在队列
+	HeaderDeathReason = "x-first-death-reason" // 死信原因：rejected（处理失败超过重试次数）、expired（过期）
+)
+
+// ErrClosed Broker 已关闭
+var ErrClosed = errors.New("memory: broker closed")
+
+var _ mq.Broker = (*Broker)(nil)
+
+// Option 内存中间件配置
+type Option struct {
+	MaxRetries int           // 处理失败后的最大重试次数，默认3，小于0时不重试
+	RetryDelay time.Duration // 处理失败后重新投递前的等待时间，默认立即投递
+}
+
+type entry struct {
+	msg      mq.Message
+	readyAt  time.Time // 可投递时间，重试等待期间不投递
+	expireAt time.Time // 过期时间，为零表示永不过期
+}
+
+type queue struct {
+	entries []*entry
+	notify  chan struct{} // 有新消息时关闭并重新创建，唤醒等待中的消费者
+}
+
+// Broker 内存消息中间件，并发安全
+type Broker struct {
+	opts   Option
+	mu     sync.Mutex
+	queues map[string]*queue
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewBroker 创建内存消息中间件，option 可为 nil
+func NewBroker(option *Option) *Broker {
+	b := &Broker{
+		opts:   Option{MaxRetries: DefaultMaxRetries},
+		queues: make(map[string]*queue),
+		closed: make(chan struct{}),
+	}
+	if option != nil {
+		b.opts.RetryDelay = option.RetryDelay
+		switch {
+		case option.MaxRetries > 0:
+			b.opts.MaxRetries = option.MaxRetries
+		case option.MaxRetries < 0:
+			b.opts.MaxRetries = 0
+		}
+	}
+	return b
+}
+
+// getQueue 获取队列，不存在时创建，需持有 b.mu
+func (b *Broker) getQueue(name string) *queue {
+	q, ok := b.queues[name]
+	if !ok {
+		q = &queue{notify: make(chan struct{})}
+		b.queues[name] = q
+	}
+	return q
+}
+
+// push 追加消息并唤醒消费者，需持有 b.mu
+func (b *Broker) push(name string, e *entry) {
+	q := b.getQueue(name)
+	q.entries = append(q.entries, e)
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+// deadLetter 将消息移入死信队列，需持有 b.mu
+func (b *Broker) deadLetter(name string, e *entry, reason string) {
+	msg := e.msg
+	headers := copyHeaders(msg.Headers, 2)
+	if _, ok := headers[HeaderDeathQueue]; !ok {
+		headers[HeaderDeathQueue] = name
+		headers[HeaderDeathReason] = reason
+	}
+	msg.Headers = headers
+	msg.TTL = 0
+	b.push(mq.DeadQueueName(name), &entry{msg: msg})
+}
+
+// Send 发布消息到 queue
+func (b *Broker) Send(ctx context.Context, queue string, msg *mq.Message) error {
+	if b.isClosed() {
+		return ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	e := &entry{msg: *msg}
+	if e.msg.ID == "" {
+		e.msg.ID = newID()
+	}
+	if e.msg.Timestamp.IsZero() {
+		e.msg.Timestamp = time.Now()
+	}
+	// 与真实的中间件一样保存消息的副本，发布后修改 msg 不影响已发布的消息
+	e.msg.Body = append([]byte(nil), msg.Body...)
+	e.msg.Headers = copyHeaders(msg.Headers, 0)
+	e.msg.Queue, e.msg.Redelivered, e.msg.RetryCount = "", false, 0
+	if msg.TTL > 0 {
+		e.expireAt = time.Now().Add(msg.TTL)
+	}
+
+	b.mu.Lock()
+	b.push(queue, e)
+	b.mu.Unlock()
+	return nil
+}
+
+// next 取出下一条可投递的消息，队列为空时等待，ctx 结束或 Broker 关闭时返回 nil
+func (b *Broker) next(ctx context.Context, name string) *entry {
+	for {
+		b.mu.Lock()
+		q := b.getQueue(name)
+		now := time.Now()
+		var (
+			found *entry
+			wake  time.Time // 最早的可投递或过期时间
+			kept  = q.entries[:0]
+		)
+		for _, e := range q.entries {
+			switch {
+			case !e.expireAt.IsZero() && !now.Before(e.expireAt):
+				b.deadLetter(name, e, "expired")
+			case found == nil && !now.Before(e.readyAt):
+				found = e
+			default:
+				kept = append(kept, e)
+				for _, t := range []time.Time{e.readyAt, e.expireAt} {
+					if t.After(now) && (wake.IsZero() || t.Before(wake)) {
+						wake = t
+					}
+				}
+			}
+		}
+		q.entries = kept
+		notify := q.notify
+		b.mu.Unlock()
+		if found != nil {
+			return found
+		}
+
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if !wake.IsZero() {
+			timer = time.NewTimer(time.Until(wake))
+			wait = timer.C
+		}
+		select {
+		case <-notify:
+		case <-wait:
+		case <-ctx.Done():
+		case <-b.closed:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil || b.isClosed() {
+			return nil
+		}
+	}
+}
+
+// Listen 消费 queue 直到 ctx 结束或 Broker 关闭，ctx 结束时返回 nil
+// 同一个 Listen 内逐条处理，多个 Listen 消费同一队列时竞争消费。
+func (b *Broker) Listen(ctx context.Context, queue string, handler mq.Handler) error {
+	for {
+		e := b.next(ctx, queue)
+		if e == nil {
+			if b.isClosed() {
+				return ErrClosed
+			}
+			return nil
+		}
+
+		// handler 收到副本，修改消息不影响重试时投递的消息
+		msg := e.msg
+		msg.Queue = queue
+		msg.Body = append([]byte(nil), e.msg.Body...)
+		msg.Headers = copyHeaders(e.msg.Headers, 0)
+		if err := call(ctx, handler, &msg); err == nil {
+			continue
+		}
+
+		b.mu.Lock()
+		if e.msg.RetryCount >= b.opts.MaxRetries {
+			b.deadLetter(queue, e, "rejected")
+		} else {
+			e.msg.RetryCount++
+			e.msg.Redelivered = true
+			e.readyAt = time.Now().Add(b.opts.RetryDelay)
+			b.push(queue, e)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// copyHeaders 复制消息头，extra 为预留的容量，headers 为空时返回 nil
+func copyHeaders(headers map[string]interface{}, extra int) map[string]interface{} {
+	if len(headers) == 0 && extra == 0 {
+		return nil
+	}
+	copied := make(map[string]interface{}, len(headers)+extra)
+	for k, v := range headers {
+		copied[k] = v
+	}
+	return copied
+}
+
+// call 执行 handler，panic 时转换为错误返回
+func call(ctx context.Context, handler mq.Handler, msg *mq.Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			err = fmt.Errorf("memory: handler panic recovered: %v\n%s", r, buf)
+		}
+	}()
+	return handler(ctx, msg)
+}
+
+// Len 队列中未被取出的消息数，包括等待重试的消息
+func (b *Broker) Len(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if q, ok := b.queues[queue]; ok {
+		return len(q.entries)
+	}
+	return 0
+}
+
+// Peek 查看队列中的消息，不会移除消息，可用于查看死信队列 mq.DeadQueueName(queue)
+func (b *Broker) Peek(queue string) []mq.Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[queue]
+	if !ok {
+		return nil
+	}
+	messages := make([]mq.Message, 0, len(q.entries))
+	for _, e := range q.entries {
+		messages = append(messages, e.msg)
+	}
+	return messages
+}
+
+// Purge 清空队列，返回清除的消息数
+func (b *Broker) Purge(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[queue]
+	if !ok {
+		return 0
+	}
+	n := len(q.entries)
+	q.entries = nil
+	return n
+}
+
+func (b *Broker) isClosed() bool {
+	select {
+	case <-b.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close 关闭 Broker，之后 Send 返回 ErrClosed，Listen 返回 ErrClosed
+func (b *Broker) Close() error {
+	b.once.Do(func() {
+		close(b.closed)
+	})
+	return nil
+}
+
+func newID() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,149 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yiuked/gopkg/mq"
+)
+
+func TestSendListen(t *testing.T) {
+	b := NewBroker(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for i := 0; i < 3; i++ {
+		if err := b.Send(ctx, "order", &mq.Message{Body: []byte("hello")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int32
+	err := b.Listen(ctx, "order", func(ctx context.Context, msg *mq.Message) error {
+		if msg.ID == "" || msg.Queue != "order" || string(msg.Body) != "hello" {
+			t.Errorf("%+v", msg)
+		}
+		if atomic.AddInt32(&count, 1) == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil || count != 3 || b.Len("order") != 0 {
+		t.Fatal(err, count, b.Len("order"))
+	}
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	b := NewBroker(&Option{MaxRetries: 2})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_ = b.Send(ctx, "order", &mq.Message{Body: []byte("fail")})
+	var calls int32
+	go func() {
+		_ = b.Listen(ctx, "order", func(ctx context.Context, msg *mq.Message) error {
+			n := atomic.AddInt32(&calls, 1)
+			if msg.RetryCount != int(n-1) || msg.Redelivered != (n > 1) {
+				t.Errorf("call %d: %+v", n, msg)
+			}
+			if n == 3 {
+				panic("boom")
+			}
+			return errors.New("fail")
+		})
+	}()
+
+	dead := mq.DeadQueueName("order")
+	for b.Len(dead) == 0 && ctx.Err() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	letters := b.Peek(dead)
+	if atomic.LoadInt32(&calls) != 3 || len(letters) != 1 {
+		t.Fatal(calls, letters)
+	}
+	if letters[0].Headers[HeaderDeathReason] != "rejected" || letters[0].Headers[HeaderDeathQueue] != "order" {
+		t.Fatal(letters[0].Headers)
+	}
+}
+
+func TestOption(t *testing.T) {
+	b := NewBroker(&Option{RetryDelay: time.Second})
+	if b.opts.MaxRetries != DefaultMaxRetries || b.opts.RetryDelay != time.Second {
+		t.Fatalf("%+v", b.opts)
+	}
+	if b = NewBroker(&Option{MaxRetries: -1}); b.opts.MaxRetries != 0 {
+		t.Fatalf("%+v", b.opts)
+	}
+	if b = NewBroker(&Option{MaxRetries: 5}); b.opts.MaxRetries != 5 {
+		t.Fatalf("%+v", b.opts)
+	}
+}
+
+func TestSendCopiesMessage(t *testing.T) {
+	b := NewBroker(&Option{MaxRetries: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	headers := map[string]interface{}{"tenant": "a"}
+	if err := b.Send(ctx, "order", &mq.Message{Headers: headers, Body: []byte("hello")}); err != nil {
+		t.Fatal(err)
+	}
+	headers["tenant"] = "b"
+
+	var seen []string
+	err := b.Listen(ctx, "order", func(ctx context.Context, msg *mq.Message) error {
+		seen = append(seen, msg.Headers["tenant"].(string))
+		if msg.RetryCount == 0 {
+			// 第一次处理时修改消息后失败，重试时收到的仍是原消息
+			msg.Headers["tenant"] = "c"
+			msg.Body[0] = 'j'
+			return errors.New("fail")
+		}
+		if string(msg.Body) != "hello" {
+			t.Error(string(msg.Body))
+		}
+		cancel()
+		return nil
+	})
+	if err != nil || len(seen) != 2 || seen[0] != "a" || seen[1] != "a" {
+		t.Fatal(err, seen)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	b := NewBroker(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_ = b.Send(ctx, "order", &mq.Message{Body: []byte("expired"), TTL: 10 * time.Millisecond})
+	time.Sleep(20 * time.Millisecond)
+	_ = b.Send(ctx, "order", &mq.Message{Body: []byte("ok")})
+
+	var bodies []string
+	_ = b.Listen(ctx, "order", func(ctx context.Context, msg *mq.Message) error {
+		bodies = append(bodies, string(msg.Body))
+		return nil
+	})
+	letters := b.Peek(mq.DeadQueueName("order"))
+	if len(bodies) != 1 || bodies[0] != "ok" || len(letters) != 1 || letters[0].Headers[HeaderDeathReason] != "expired" {
+		t.Fatal(bodies, letters)
+	}
+}
+
+func TestClose(t *testing.T) {
+	b := NewBroker(nil)
+	done := make(chan error)
+	go func() {
+		done <- b.Listen(context.Background(), "order", func(ctx context.Context, msg *mq.Message) error {
+			return nil
+		})
+	}()
+	_ = b.Close()
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	}
+	if err := b.Send(context.Background(), "order", &mq.Message{}); !errors.Is(err, ErrClosed) {
+		t.Fatal(err)
+	}
+}
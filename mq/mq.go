@@ -0,0 +1,51 @@
+// Package mq 定义与具体消息中间件无关的发布/订阅接口
+//
+// rabbit.Client 实现了这些接口，memory.Broker 提供了同样语义的内存实现，用于单元测试与本地开发。
+package mq
+
+import (
+	"context"
+	"time"
+)
+
+// Message 与中间件无关的消息
+type Message struct {
+	ID            string                 // 消息ID，为空时发布端自动生成
+	CorrelationID string                 // 关联ID
+	Type          string                 // 消息类型
+	Timestamp     time.Time              // 发布时间，为空时发布端自动设置
+	Headers       map[string]interface{} // 自定义消息头
+	Body          []byte                 // 消息体
+	TTL           time.Duration          // 消息有效期，超时未被消费则进入死信队列，0 表示永久有效
+
+	Queue       string // 消费的队列名称，消费端设置
+	Redelivered bool   // 是否为重新投递的消息，消费端设置
+	RetryCount  int    // 已重试次数，消费端设置
+}
+
+// Handler 消费端处理函数
+// 返回 nil 时确认消息；返回错误或 panic 时消息被重新投递，超过重试次数后进入死信队列 dead:<queue>。
+type Handler func(ctx context.Context, msg *Message) error
+
+// Publisher 发布端
+type Publisher interface {
+	// Send 发布消息到 queue，队列不存在时自动创建
+	Send(ctx context.Context, queue string, msg *Message) error
+}
+
+// Subscriber 消费端
+type Subscriber interface {
+	// Listen 消费 queue 直到 ctx 结束，多个消费者消费同一队列时每条消息只会被其中一个处理
+	Listen(ctx context.Context, queue string, handler Handler) error
+}
+
+// Broker 同时具备发布与消费能力的消息中间件
+type Broker interface {
+	Publisher
+	Subscriber
+}
+
+// DeadQueueName 队列对应的死信队列名称
+func DeadQueueName(queue string) string {
+	return "dead:" + queue
+}
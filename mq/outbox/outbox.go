@@ -0,0 +1,311 @@
+// Package outbox 事务发件箱
+//
+// 业务数据与待发布的消息在同一个数据库事务中写入，由后台中继将未发布的消息以确认模式发布到消息中间件，
+// 避免写库成功后发布前进程崩溃导致消息丢失。消息至少发布一次，消费端需要幂等处理。
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yiuked/gopkg/mq"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 消息状态
+const (
+	StatusPending = 0 // 待发布
+	StatusSent    = 1 // 已发布
+	StatusFailed  = 2 // 超过最大重试次数，不再发布
+)
+
+// Message 发件箱中的消息
+type Message struct {
+	ID            uint64     `gorm:"primaryKey"`
+	Queue         string     `gorm:"size:255;not null"`
+	MessageID     string     `gorm:"size:64;not null"`
+	CorrelationID string     `gorm:"size:64"`
+	Type          string     `gorm:"size:255"`
+	Headers       string     `gorm:"type:text"` // JSON
+	Body          []byte     `gorm:"not null"`
+	TTL           int64      `gorm:"not null;default:0"` // 消息有效期（毫秒），0 表示永久有效
+	Status        int8       `gorm:"not null;default:0;index:idx_outbox_status_next,priority:1"`
+	Attempts      int        `gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_outbox_status_next,priority:2"`
+	LastError     string     `gorm:"size:1024"`
+	CreatedAt     time.Time  `gorm:"not null"`
+	SentAt        *time.Time `gorm:"index"`
+}
+
+func (Message) TableName() string {
+	return "mq_outbox"
+}
+
+// Option 中继配置
+type Option struct {
+	BatchSize       int           // 每次读取的消息数，默认100
+	Interval        time.Duration // 没有待发布消息时的轮询间隔，默认1秒
+	MaxAttempts     int           // 最大发布次数，超过后标记为失败，0 表示一直重试
+	RetryDelay      time.Duration // 发布失败后首次重试的等待时间，之后按指数增长，默认1秒
+	RetryMaxDelay   time.Duration // 最大重试等待时间，默认5分钟
+	Retention       time.Duration // 已发布消息的保留时间，默认7天
+	CleanupInterval time.Duration // 清理已发布消息的间隔，默认1小时
+	SkipLocked      bool          // 读取时使用 FOR UPDATE SKIP LOCKED 加锁，多实例同时运行中继时开启（MySQL 8.0+）
+	ClaimTimeout    time.Duration // 中继取出消息后占用的时间，期间不会被再次取出，应大于发布一批消息的耗时，默认1分钟
+}
+
+// Outbox 事务发件箱
+type Outbox struct {
+	db        *gorm.DB
+	publisher mq.Publisher
+	opts      Option
+	wake      chan struct{}
+}
+
+// New 创建发件箱，publisher 通常为 *rabbit.Client，option 可为 nil
+func New(db *gorm.DB, publisher mq.Publisher, option *Option) *Outbox {
+	o := &Outbox{db: db, publisher: publisher, wake: make(chan struct{}, 1)}
+	if option != nil {
+		o.opts = *option
+	}
+	if o.opts.BatchSize <= 0 {
+		o.opts.BatchSize = 100
+	}
+	if o.opts.Interval <= 0 {
+		o.opts.Interval = time.Second
+	}
+	if o.opts.RetryDelay <= 0 {
+		o.opts.RetryDelay = time.Second
+	}
+	if o.opts.RetryMaxDelay <= 0 {
+		o.opts.RetryMaxDelay = 5 * time.Minute
+	}
+	if o.opts.Retention <= 0 {
+		o.opts.Retention = 7 * 24 * time.Hour
+	}
+	if o.opts.CleanupInterval <= 0 {
+		o.opts.CleanupInterval = time.Hour
+	}
+	if o.opts.ClaimTimeout <= 0 {
+		o.opts.ClaimTimeout = time.Minute
+	}
+	return o
+}
+
+// AutoMigrate 创建或更新发件箱表
+func (o *Outbox) AutoMigrate() error {
+	return o.db.AutoMigrate(&Message{})
+}
+
+// Add 在事务 tx 中写入待发布的消息，事务提交后由中继发布
+// msg.ID 为空时生成，重试发布时消息ID不变，消费端可以据此去重。
+// msg.TTL 从消息发布到中间件时开始计算，不包含在发件箱中等待的时间。
+func (o *Outbox) Add(tx *gorm.DB, queue string, msg *mq.Message) error {
+	if msg.ID == "" {
+		msg.ID = newID()
+	}
+	var headers string
+	if len(msg.Headers) > 0 {
+		data, err := json.Marshal(msg.Headers)
+		if err != nil {
+			return fmt.Errorf("outbox: marshal headers: %w", err)
+		}
+		headers = string(data)
+	}
+	now := time.Now()
+	row := &Message{
+		Queue:         queue,
+		MessageID:     msg.ID,
+		CorrelationID: msg.CorrelationID,
+		Type:          msg.Type,
+		Headers:       headers,
+		Body:          msg.Body,
+		TTL:           msg.TTL.Milliseconds(),
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	if err := tx.Create(row).Error; err != nil {
+		return fmt.Errorf("outbox: add message: %w", err)
+	}
+	return nil
+}
+
+// Notify 唤醒中继立即发布，通常在事务提交后调用，不调用时等待下一次轮询
+func (o *Outbox) Notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run 运行中继直到 ctx 结束，发布待发布的消息并定期清理已发布的消息
+func (o *Outbox) Run(ctx context.Context) error {
+	return o.run(ctx, o.Relay, o.Cleanup)
+}
+
+// run 循环执行 relay，每轮检查是否到了清理时间，持续有消息待发布时同样会清理
+func (o *Outbox) run(ctx context.Context, relay func(ctx context.Context) (int, error), cleanup func(ctx context.Context) (int64, error)) error {
+	lastCleanup := time.Now()
+	for {
+		if time.Since(lastCleanup) >= o.opts.CleanupInterval {
+			lastCleanup = time.Now()
+			if _, err := cleanup(ctx); err != nil {
+				log.Println("outbox: cleanup err,", err.Error())
+			}
+		}
+
+		n, err := relay(ctx)
+		if err != nil {
+			log.Println("outbox: relay err,", err.Error())
+		}
+		// 一批已满说明可能还有待发布的消息，立即继续
+		if err == nil && n >= o.opts.BatchSize && ctx.Err() == nil {
+			continue
+		}
+
+		timer := time.NewTimer(o.opts.Interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-o.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Relay 发布一批到期的待发布消息，返回读取的消息数
+// 先在短事务中取出消息并将其下次发布时间推迟 ClaimTimeout 以占用这些消息，再在事务外逐条发布并各自更新状态，
+// 发布期间不持有行锁，一条消息更新失败不影响其它消息。发布后更新前进程退出时，消息在 ClaimTimeout 后会再次发布。
+func (o *Outbox) Relay(ctx context.Context) (int, error) {
+	rows, err := o.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var firstErr error
+	for i := range rows {
+		if ctx.Err() != nil {
+			// 未发布的消息在占用超时后由下一次中继发布
+			return i, ctx.Err()
+		}
+		updates := o.send(ctx, &rows[i], time.Now())
+		err := o.db.WithContext(ctx).Model(&Message{}).Where("id = ?", rows[i].ID).Updates(updates).Error
+		if err != nil {
+			log.Println("outbox: update message err,", rows[i].ID, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return len(rows), firstErr
+}
+
+// claim 取出一批到期的待发布消息并推迟其下次发布时间
+func (o *Outbox) claim(ctx context.Context) ([]Message, error) {
+	var rows []Message
+	err := o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		query := tx.Where("status = ? AND next_attempt_at <= ?", StatusPending, now).
+			Order("id").Limit(o.opts.BatchSize)
+		if o.opts.SkipLocked {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+		if err := query.Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		ids := make([]uint64, len(rows))
+		for i, row := range rows {
+			ids[i] = row.ID
+		}
+		return tx.Model(&Message{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(o.opts.ClaimTimeout)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// send 发布一条消息，返回需要更新的字段
+func (o *Outbox) send(ctx context.Context, row *Message, now time.Time) map[string]interface{} {
+	msg := &mq.Message{
+		ID:            row.MessageID,
+		CorrelationID: row.CorrelationID,
+		Type:          row.Type,
+		Timestamp:     row.CreatedAt,
+		Body:          row.Body,
+		TTL:           time.Duration(row.TTL) * time.Millisecond,
+	}
+	if row.Headers != "" {
+		if err := json.Unmarshal([]byte(row.Headers), &msg.Headers); err != nil {
+			log.Println("outbox: unmarshal headers err,", row.ID, err.Error())
+		}
+	}
+
+	updates := map[string]interface{}{"attempts": row.Attempts + 1}
+	if err := o.publisher.Send(ctx, row.Queue, msg); err != nil {
+		lastErr := err.Error()
+		if len(lastErr) > 1024 {
+			lastErr = lastErr[:1024]
+		}
+		updates["last_error"] = lastErr
+		updates["next_attempt_at"] = now.Add(o.backoff(row.Attempts))
+		if o.opts.MaxAttempts > 0 && row.Attempts+1 >= o.opts.MaxAttempts {
+			updates["status"] = StatusFailed
+		}
+	} else {
+		updates["status"] = StatusSent
+		updates["sent_at"] = now
+	}
+	return updates
+}
+
+// backoff 第 attempts 次失败后的重试等待时间
+func (o *Outbox) backoff(attempts int) time.Duration {
+	delay := o.opts.RetryDelay
+	for i := 0; i < attempts && delay < o.opts.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > o.opts.RetryMaxDelay {
+		delay = o.opts.RetryMaxDelay
+	}
+	return delay
+}
+
+// Cleanup 删除超过保留时间的已发布消息，返回删除的消息数
+func (o *Outbox) Cleanup(ctx context.Context) (int64, error) {
+	result := o.db.WithContext(ctx).
+		Where("status = ? AND sent_at < ?", StatusSent, time.Now().Add(-o.opts.Retention)).
+		Delete(&Message{})
+	return result.RowsAffected, result.Error
+}
+
+// Retry 将失败的消息重新标记为待发布，ids 为空时重置全部失败消息，返回重置的消息数
+func (o *Outbox) Retry(ctx context.Context, ids ...uint64) (int64, error) {
+	query := o.db.WithContext(ctx).Model(&Message{}).Where("status = ?", StatusFailed)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	result := query.Updates(map[string]interface{}{
+		"status":          StatusPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	})
+	return result.RowsAffected, result.Error
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,106 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yiuked/gopkg/mq"
+)
+
+type fakePublisher struct {
+	err   error
+	queue string
+	msg   *mq.Message
+}
+
+func (p *fakePublisher) Send(ctx context.Context, queue string, msg *mq.Message) error {
+	p.queue, p.msg = queue, msg
+	return p.err
+}
+
+func TestBackoff(t *testing.T) {
+	o := New(nil, nil, &Option{RetryDelay: time.Second, RetryMaxDelay: 10 * time.Second})
+	for attempts, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second} {
+		if got := o.backoff(attempts); got != want {
+			t.Fatal(attempts, got, want)
+		}
+	}
+	if got := o.backoff(100); got != 10*time.Second {
+		t.Fatal(got)
+	}
+
+	// 默认值
+	o = New(nil, nil, nil)
+	if o.backoff(0) != time.Second || o.backoff(30) != 5*time.Minute {
+		t.Fatal(o.backoff(0), o.backoff(30))
+	}
+}
+
+func TestSend(t *testing.T) {
+	pub := &fakePublisher{}
+	o := New(nil, pub, &Option{MaxAttempts: 3})
+	now := time.Now()
+	row := &Message{
+		ID:        1,
+		Queue:     "order",
+		MessageID: "m1",
+		Type:      "order.created",
+		Headers:   `{"tenant":"a"}`,
+		Body:      []byte("hello"),
+		TTL:       60000,
+		CreatedAt: now.Add(-time.Minute),
+	}
+
+	updates := o.send(context.Background(), row, now)
+	if updates["status"] != StatusSent || updates["attempts"] != 1 || updates["sent_at"] != now {
+		t.Fatal(updates)
+	}
+	if pub.queue != "order" || pub.msg.ID != "m1" || pub.msg.TTL != time.Minute || pub.msg.Headers["tenant"] != "a" ||
+		!pub.msg.Timestamp.Equal(row.CreatedAt) {
+		t.Fatalf("%+v", pub.msg)
+	}
+
+	// 发布失败：记录错误并按退避时间重试
+	pub.err = errors.New(strings.Repeat("x", 2000))
+	row.Attempts = 1
+	updates = o.send(context.Background(), row, now)
+	if _, ok := updates["status"]; ok || updates["attempts"] != 2 {
+		t.Fatal(updates)
+	}
+	if updates["next_attempt_at"] != now.Add(2*time.Second) || len(updates["last_error"].(string)) != 1024 {
+		t.Fatal(updates["next_attempt_at"], len(updates["last_error"].(string)))
+	}
+
+	// 达到最大发布次数后标记为失败
+	row.Attempts = 2
+	updates = o.send(context.Background(), row, now)
+	if updates["status"] != StatusFailed || updates["attempts"] != 3 {
+		t.Fatal(updates)
+	}
+}
+
+func TestRunCleanupWhileBusy(t *testing.T) {
+	o := New(nil, nil, &Option{BatchSize: 10, CleanupInterval: 10 * time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	var relays, cleanups int
+	// 每次都取满一批，中继一直不空闲
+	relay := func(ctx context.Context) (int, error) {
+		relays++
+		time.Sleep(time.Millisecond)
+		return 10, nil
+	}
+	cleanup := func(ctx context.Context) (int64, error) {
+		cleanups++
+		return 0, nil
+	}
+	if err := o.run(ctx, relay, cleanup); err != nil {
+		t.Fatal(err)
+	}
+	if cleanups < 2 || relays < 20 {
+		t.Fatal(cleanups, relays)
+	}
+}
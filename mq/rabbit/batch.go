@@ -0,0 +1,413 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	DefaultBatchSize = 100         // ConsumeBatch 默认每批消息数
+	DefaultBatchWait = time.Second // ConsumeBatch 默认等待凑满一批的时间
+)
+
+// errUnsettled 管道上还有未读取的确认通知，归还时必须丢弃
+var errUnsettled = errors.New("rabbit: channel has unsettled confirms")
+
+// BatchError 批量发布时部分消息失败，Errors 的键为消息在批次中的下标
+// 可以通过 errors.Is(err, ErrNack) 等判断是否包含某类错误
+type BatchError struct {
+	Total  int
+	Errors map[int]error
+}
+
+func (e *BatchError) Error() string {
+	index := e.indexes()
+	return fmt.Sprintf("rabbit: %d of %d messages failed to publish, first at %d: %v",
+		len(index), e.Total, index[0], e.Errors[index[0]])
+}
+
+// Is 任一消息的错误匹配 target 时返回 true，Go 1.20 之前 errors.Is 不会展开 Unwrap() []error
+func (e *BatchError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As 按下标顺序将第一个匹配 target 的错误赋给 target
+func (e *BatchError) As(target interface{}) bool {
+	for _, n := range e.indexes() {
+		if errors.As(e.Errors[n], target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *BatchError) Unwrap() []error {
+	index := e.indexes()
+	errs := make([]error, len(index))
+	for i, n := range index {
+		errs[i] = e.Errors[n]
+	}
+	return errs
+}
+
+func (e *BatchError) indexes() []int {
+	index := make([]int, 0, len(e.Errors))
+	for n := range e.Errors {
+		index = append(index, n)
+	}
+	sort.Ints(index)
+	return index
+}
+
+// PublishBatch 以确认模式批量发布消息到 queue，与 PublishMessage 一样会声明 queue（不设置过期时间）
+// 所有消息在同一个管道上连续发出后统一等待确认，吞吐远高于逐条等待确认。
+// 没有 MessageId 的消息会生成一个，用于匹配 broker 退回的消息。
+// 部分消息失败时返回 *BatchError，其中记录每条失败消息的下标与原因，调用方可只重发失败的消息。
+func (c *Client) PublishBatch(ctx context.Context, queue string, msgs []amqp.Publishing) (err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.confirmTimeout())
+		defer cancel()
+	}
+	pc, err := c.confirmPool.get(ctx)
+	if err != nil {
+		return err
+	}
+	healthy := false
+	defer func() {
+		if healthy {
+			c.confirmPool.put(pc, nil)
+		} else {
+			// 不论批次错误是什么（即使只是 ErrNack）都丢弃管道，读取确认的协程可能还在运行
+			c.confirmPool.put(pc, errUnsettled)
+		}
+	}()
+	if err = c.ensureQueue(pc.ch, queue, 0); err != nil {
+		return err
+	}
+	healthy, err = c.publishBatch(ctx, pc, "", queue, msgs)
+	return err
+}
+
+// PublishExchangeBatch 以确认模式批量发布消息到指定交换机，其它行为与 PublishBatch 一致
+func (c *Client) PublishExchangeBatch(ctx context.Context, exchange, routingKey string, msgs []amqp.Publishing) (err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.confirmTimeout())
+		defer cancel()
+	}
+	pc, err := c.confirmPool.get(ctx)
+	if err != nil {
+		return err
+	}
+	healthy := false
+	defer func() {
+		if healthy {
+			c.confirmPool.put(pc, nil)
+		} else {
+			// 不论批次错误是什么（即使只是 ErrNack）都丢弃管道，读取确认的协程可能还在运行
+			c.confirmPool.put(pc, errUnsettled)
+		}
+	}()
+	healthy, err = c.publishBatch(ctx, pc, exchange, routingKey, msgs)
+	return err
+}
+
+// publishBatch 在确认模式的管道上连续发布 msgs 并等待全部确认
+// 确认通知在发布的同时由另一个协程读取，避免大批量发布时客户端阻塞在未读取的通知上。
+// healthy 表示所有已发出的消息都收到了确认，管道可以继续使用。
+func (c *Client) publishBatch(ctx context.Context, pc *pooledChannel, exchange, routingKey string, msgs []amqp.Publishing) (healthy bool, err error) {
+	if len(msgs) == 0 {
+		return true, nil
+	}
+	hook := c.getHook()
+	events := make([]*PublishEvent, len(msgs))
+	ctxs := make([]context.Context, len(msgs))
+	ids := make(map[string][]int, len(msgs))
+	for i := range msgs {
+		msg := &msgs[i]
+		if msg.MessageId == "" {
+			msg.MessageId = NewMessageID()
+		}
+		ids[msg.MessageId] = append(ids[msg.MessageId], i)
+		events[i] = &PublishEvent{
+			Exchange:   exchange,
+			RoutingKey: routingKey,
+			MessageID:  msg.MessageId,
+			Size:       len(msg.Body),
+			Confirm:    true,
+		}
+		ctxs[i] = hook.PublishStart(ctx, events[i])
+		injectTrace(ctxs[i], msg)
+	}
+
+	// 确认按发布顺序到达，退回的消息先于其确认到达，按 MessageId 匹配
+	acks := make(chan bool, len(msgs))
+	var returns []amqp.Return
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case confirm, ok := <-pc.confirms:
+				if !ok {
+					return
+				}
+				acks <- confirm.Ack
+			case ret, ok := <-pc.returns:
+				if ok {
+					returns = append(returns, ret)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	errs := make(map[int]error)
+	start := time.Now()
+	published := len(msgs)
+	for i, msg := range msgs {
+		if err := pc.ch.Publish(exchange, routingKey, true, false, msg); err != nil {
+			published = i
+			for j := i; j < len(msgs); j++ {
+				errs[j] = fmt.Errorf("%s: %s", "Failed to publish a message", err)
+			}
+			break
+		}
+	}
+	sent := time.Now()
+
+	confirmed := 0
+wait:
+	for confirmed < published {
+		select {
+		case ack := <-acks:
+			if !ack {
+				errs[confirmed] = ErrNack
+			}
+			events[confirmed].ConfirmLatency = time.Since(sent)
+			confirmed++
+		case <-stopped:
+			// 管道关闭，未确认的消息结果未知
+			for j := confirmed; j < published; j++ {
+				errs[j] = fmt.Errorf("%s: %s", "Failed to wait publish confirm", amqp.ErrClosed)
+			}
+			break wait
+		case <-ctx.Done():
+			timeout := ctx.Err()
+			if errors.Is(timeout, context.DeadlineExceeded) {
+				timeout = ErrConfirmTimeout
+			}
+			for j := confirmed; j < published; j++ {
+				errs[j] = timeout
+			}
+			break wait
+		}
+	}
+	healthy = confirmed == published && published == len(msgs)
+	if healthy {
+		close(stop)
+		<-stopped
+		// 最后一条确认之前的退回一定已经在通知中
+	drain:
+		for {
+			select {
+			case ret := <-pc.returns:
+				returns = append(returns, ret)
+			default:
+				break drain
+			}
+		}
+		for _, ret := range returns {
+			index := ids[ret.MessageId]
+			if len(index) == 0 {
+				continue
+			}
+			ids[ret.MessageId] = index[1:]
+			errs[index[0]] = &ReturnError{
+				Exchange:   ret.Exchange,
+				RoutingKey: ret.RoutingKey,
+				ReplyCode:  ret.ReplyCode,
+				ReplyText:  ret.ReplyText,
+			}
+		}
+	}
+	// 管道不可再用时，读取通知的协程继续运行，直到管道关闭后通知被关闭
+
+	duration := time.Since(start)
+	for i, e := range events {
+		e.Duration = duration
+		e.Err = errs[i]
+		hook.PublishDone(ctxs[i], e)
+	}
+	if len(errs) > 0 {
+		return healthy, &BatchError{Total: len(msgs), Errors: errs}
+	}
+	return healthy, nil
+}
+
+// BatchHandler 批量消费处理函数，返回 nil 时整批确认，返回错误时每条消息分别按重试策略处理
+// 也可以在 handler 中手动确认部分消息，此时其余消息逐条确认。
+type BatchHandler func(ctx context.Context, msgs []*Message) error
+
+// ConsumeBatch 批量消费 queue，每批最多 WithBatch 设置的条数，或等待超时后不足一批也交给 handler
+// 成功处理的一批以 multiple=true 一次确认。批次依次处理，同一时刻只有一批在处理中，
+// WithWorkers、WithOrdered 与 WithMiddleware 不生效，其它行为与 Consume 一致。
+func (c *Client) ConsumeBatch(ctx context.Context, queue string, expire int64, handler BatchHandler, opts ...ConsumeOption) error {
+	o := newConsumeOptions(opts)
+	if o.batchSize <= 0 {
+		o.batchSize = DefaultBatchSize
+	}
+	if o.batchWait <= 0 {
+		o.batchWait = DefaultBatchWait
+	}
+	if o.prefetch < o.batchSize {
+		o.prefetch = o.batchSize
+	}
+	o.batch = func(ctx context.Context, deliveries []amqp.Delivery) {
+		c.handleBatch(ctx, o, queue, deliveries, handler)
+	}
+	return c.consume(ctx, o, c.consumeQueue(queue, expire, o), nil)
+}
+
+// dispatchBatch 按批分发消息，凑满一批或等待超时后在当前协程处理
+// ctx 结束时取消订阅，已收到的消息处理完（最多 ShutdownTimeout）后返回 true。
+func (c *Client) dispatchBatch(ctx context.Context, o *consumeOptions, ch *amqp.Channel, tag string, msgChan <-chan amqp.Delivery) bool {
+	hctx, hcancel := context.WithCancel(detach(ctx))
+	defer hcancel()
+	defer closeChannel(ch)
+
+	var batch []amqp.Delivery
+	timer := time.NewTimer(o.batchWait)
+	timer.Stop()
+	flush := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if len(batch) > 0 {
+			o.batch(hctx, batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case msg, ok := <-msgChan:
+			if !ok {
+				return false
+			}
+			batch = append(batch, msg)
+			if len(batch) == 1 {
+				timer.Reset(o.batchWait)
+			}
+			if len(batch) >= o.batchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		case <-ctx.Done():
+			if err := ch.Cancel(tag, false); err != nil {
+				log.Println("cancel consumer err", err.Error())
+			}
+			done := make(chan struct{})
+			go func() {
+				flush()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(c.shutdownTimeout()):
+				log.Println("Wait consumer handlers timeout,", tag)
+			}
+			return true
+		}
+	}
+}
+
+// handleBatch 执行批量 handler 并确认消息，处理前后对每条消息通知 Hook
+func (c *Client) handleBatch(ctx context.Context, o *consumeOptions, queue string, deliveries []amqp.Delivery, handler BatchHandler) {
+	hook := c.getHook()
+	msgs := make([]*Message, len(deliveries))
+	events := make([]*ConsumeEvent, len(deliveries))
+	ctxs := make([]context.Context, len(deliveries))
+	for i, d := range deliveries {
+		msgs[i] = &Message{Delivery: d, Queue: queue}
+		events[i] = &ConsumeEvent{
+			Queue:       queue,
+			Exchange:    d.Exchange,
+			RoutingKey:  d.RoutingKey,
+			MessageID:   d.MessageId,
+			RetryCount:  msgs[i].RetryCount(),
+			Redelivered: d.Redelivered,
+		}
+		mctx := ctx
+		if tc, ok := extractTrace(d.Headers); ok {
+			mctx = ContextWithTrace(ctx, tc)
+		}
+		ctxs[i] = hook.ConsumeStart(mctx, events[i])
+	}
+
+	start := time.Now()
+	err := callBatch(ctx, handler, msgs)
+	duration := time.Since(start)
+
+	manual := false
+	for _, msg := range msgs {
+		manual = manual || msg.Settled()
+	}
+	for i, msg := range msgs {
+		e := events[i]
+		e.Duration, e.Err = duration, err
+		switch {
+		case err == nil && !manual:
+			e.Result = ResultAck
+		default:
+			e.Result = c.settle(o, queue, msg, err)
+		}
+	}
+	if err == nil && !manual {
+		// 一次确认这一批中所有的消息（同一管道上序号不大于最后一条的未确认消息）
+		if err := ackMultiple(msgs); err != nil {
+			log.Println("ack messages err", err.Error())
+		}
+	}
+	for i, e := range events {
+		hook.ConsumeDone(ctxs[i], e)
+	}
+}
+
+// ackMultiple 以 multiple=true 确认 msgs 中的所有消息
+func ackMultiple(msgs []*Message) error {
+	for _, msg := range msgs {
+		atomic.StoreInt32(&msg.settled, 1)
+	}
+	return msgs[len(msgs)-1].Delivery.Ack(true)
+}
+
+// callBatch 执行批量 handler，panic 时转换为错误返回
+func callBatch(ctx context.Context, handler BatchHandler, msgs []*Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			err = fmt.Errorf("rabbit: batch handler panic recovered: %v\n%s", r, buf)
+		}
+	}()
+	return handler(ctx, msgs)
+}
@@ -0,0 +1,108 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// DefaultConfirmTimeout 未配置 Option.ConfirmTimeout 且 ctx 未设置截止时间时，等待确认的超时时间
+const DefaultConfirmTimeout = 5 * time.Second
+
+var (
+	ErrNack           = errors.New("rabbit: message nacked by broker")
+	ErrUnroutable     = errors.New("rabbit: message unroutable")
+	ErrConfirmTimeout = errors.New("rabbit: wait for publish confirm timeout")
+)
+
+// ReturnError mandatory 消息无法路由到任何队列时，broker 退回的消息信息
+// 可以通过 errors.Is(err, ErrUnroutable) 判断
+type ReturnError struct {
+	Exchange   string
+	RoutingKey string
+	ReplyCode  uint16
+	ReplyText  string
+}
+
+func (e *ReturnError) Error() string {
+	return fmt.Sprintf("rabbit: message returned, exchange:%q routing key:%q reply:%d %s",
+		e.Exchange, e.RoutingKey, e.ReplyCode, e.ReplyText)
+}
+
+func (e *ReturnError) Is(target error) bool {
+	return target == ErrUnroutable
+}
+
+// PublishWithConfirm 以确认模式发布信息，等待 broker 的 ack/nack 后返回
+// 参数含义与 Publish 一致，消息以 mandatory 方式发布，无法路由时返回 *ReturnError，
+// broker 拒绝时返回 ErrNack，超时返回 ErrConfirmTimeout。
+func (c *Client) PublishWithConfirm(ctx context.Context, queue string, body []byte, expire int64, opts ...PublishOption) error {
+	msg := newPublishing(body, expire)
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	return c.publish(ctx, true, "", queue, true, msg, func(ch *amqp.Channel) error {
+		return c.ensureQueue(ch, queue, expire)
+	})
+}
+
+// PublishExchangeWithConfirm 以确认模式发布信息到指定交换机，参数含义与 PublishExchange 一致
+func (c *Client) PublishExchangeWithConfirm(ctx context.Context, exchange, routingKey string, body []byte, headers amqp.Table, opts ...PublishOption) error {
+	msg := newPublishing(body, 0)
+	msg.Headers = headers
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	return c.confirmPublish(ctx, exchange, routingKey, msg)
+}
+
+// confirmPublish 以确认模式、mandatory 方式发布并等待确认
+func (c *Client) confirmPublish(ctx context.Context, exchange, routingKey string, msg amqp.Publishing) error {
+	return c.publish(ctx, true, exchange, routingKey, true, msg, nil)
+}
+
+func (c *Client) confirmTimeout() time.Duration {
+	if c.opts.ConfirmTimeout > 0 {
+		return time.Duration(c.opts.ConfirmTimeout) * time.Second
+	}
+	return DefaultConfirmTimeout
+}
+
+// waitConfirm 等待一条消息的确认结果
+// broker 对无法路由的 mandatory 消息会先发送 basic.return 再发送 basic.ack，
+// 而客户端按顺序分发这两类通知，所以收到 ack 时退回信息（如果有）已经在 returns 中。
+func (c *Client) waitConfirm(ctx context.Context, confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.confirmTimeout())
+		defer cancel()
+	}
+	select {
+	case confirm, ok := <-confirms:
+		if !ok {
+			return fmt.Errorf("%s: %s", "Failed to wait publish confirm", amqp.ErrClosed)
+		}
+		select {
+		case ret := <-returns:
+			return &ReturnError{
+				Exchange:   ret.Exchange,
+				RoutingKey: ret.RoutingKey,
+				ReplyCode:  ret.ReplyCode,
+				ReplyText:  ret.ReplyText,
+			}
+		default:
+		}
+		if !confirm.Ack {
+			return ErrNack
+		}
+		return nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return ErrConfirmTimeout
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,433 @@
+package rabbit
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	DefaultReconnectDelay    = 1  // 默认首次重连等待时间（秒）
+	DefaultReconnectMaxDelay = 30 // 默认最大重连等待时间（秒）
+)
+
+// binding 已绑定的队列，断线重连后重新绑定
+type binding struct {
+	queue      string
+	exchange   string
+	routingKey string
+	args       amqp.Table
+}
+
+func (b binding) key() string {
+	return fmt.Sprintf("%s|%s|%s|%v", b.queue, b.exchange, b.routingKey, b.args)
+}
+
+// declaredQueue 发布端已声明的队列，断线重连后重新声明
+type declaredQueue struct {
+	queue  string
+	expire int64
+}
+
+// NodeOrder 连接集群时尝试节点的顺序
+type NodeOrder string
+
+const (
+	NodeRoundRobin NodeOrder = "round_robin" // 轮询，每次连接从上次之后的节点开始，依次尝试所有节点
+	NodeShuffle    NodeOrder = "shuffle"     // 每次连接随机打乱节点顺序，使大量客户端均匀分布到各节点
+)
+
+// DefaultHeartbeat 默认心跳间隔（秒）
+const DefaultHeartbeat = 30
+
+// TLSOption TLS 连接配置，文件均为 PEM 格式
+type TLSOption struct {
+	CAFile             string `mapstructure:"ca_file" json:"ca_file" yaml:"ca_file"`                                        // 自定义根证书，为空时使用系统根证书
+	CertFile           string `mapstructure:"cert_file" json:"cert_file" yaml:"cert_file"`                                  // 客户端证书，broker 开启双向认证时需要
+	KeyFile            string `mapstructure:"key_file" json:"key_file" yaml:"key_file"`                                     // 客户端私钥
+	ServerName         string `mapstructure:"server_name" json:"server_name" yaml:"server_name"`                            // 校验证书使用的主机名，为空时使用连接地址中的主机名
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify" json:"insecure_skip_verify" yaml:"insecure_skip_verify"` // 不校验服务端证书，仅用于测试
+}
+
+// config 生成 tls.Config，o 为 nil 时返回 nil
+func (o *TLSOption) config() (*tls.Config, error) {
+	if o == nil {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", "Failed to read CA file", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("rabbit: no certificate found in %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", "Failed to load client certificate", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// urls 合并 Url 与 Urls，去除空值与重复地址
+func (o *Option) urls() []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, url := range append([]string{o.Url}, o.Urls...) {
+		if url == "" || seen[url] {
+			continue
+		}
+		seen[url] = true
+		urls = append(urls, url)
+	}
+	return urls
+}
+
+// nodes 本次连接尝试节点的顺序
+func (c *Client) nodes() []string {
+	urls := make([]string, len(c.urls))
+	if c.opts.NodeOrder == NodeShuffle {
+		var perm []int
+		c.random(func(r *rand.Rand) {
+			perm = r.Perm(len(c.urls))
+		})
+		for i, j := range perm {
+			urls[i] = c.urls[j]
+		}
+		return urls
+	}
+	start := int(atomic.AddUint32(&c.next, 1)-1) % len(c.urls)
+	for i := range urls {
+		urls[i] = c.urls[(start+i)%len(c.urls)]
+	}
+	return urls
+}
+
+func (c *Client) config() amqp.Config {
+	heartbeat := time.Duration(DefaultHeartbeat) * time.Second
+	if c.opts.Heartbeat > 0 {
+		heartbeat = time.Duration(c.opts.Heartbeat) * time.Second
+	}
+	config := amqp.Config{
+		Heartbeat: heartbeat,
+		Vhost:     c.opts.Vhost,
+		Locale:    "en_US",
+	}
+	if c.tls != nil {
+		// DialConfig 会修改 ServerName，每次连接使用副本
+		config.TLSClientConfig = c.tls.Clone()
+	}
+	if c.opts.ConnectionName != "" {
+		// 设置 Properties 后客户端不再填充默认属性，需要一并设置
+		config.Properties = amqp.Table{
+			"product":         "github.com/yiuked/gopkg/mq/rabbit",
+			"connection_name": c.opts.ConnectionName,
+		}
+	}
+	return config
+}
+
+// dial 按 NodeOrder 依次尝试连接各节点，全部失败时返回每个节点的错误
+func (c *Client) dial() (*amqp.Connection, error) {
+	var errs []string
+	for _, url := range c.nodes() {
+		conn, err := amqp.DialConfig(url, c.config())
+		if err == nil {
+			return conn, nil
+		}
+		if len(c.urls) == 1 {
+			return nil, err
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", redactURL(url), err))
+	}
+	return nil, fmt.Errorf("all nodes failed: %s", strings.Join(errs, "; "))
+}
+
+// redactURL 隐藏地址中的密码，用于日志与错误信息
+func redactURL(url string) string {
+	uri, err := amqp.ParseURI(url)
+	if err != nil {
+		return "<invalid url>"
+	}
+	return fmt.Sprintf("%s://%s:%d", uri.Scheme, uri.Host, uri.Port)
+}
+
+// OnDisconnect 设置连接异常断开时的回调
+func (c *Client) OnDisconnect(fn func(err error)) {
+	c.mu.Lock()
+	c.onDisconnect = fn
+	c.mu.Unlock()
+}
+
+// OnReconnect 设置重连成功时的回调，attempt 为本次断线后的重连次数
+func (c *Client) OnReconnect(fn func(attempt int)) {
+	c.mu.Lock()
+	c.onReconnect = fn
+	c.mu.Unlock()
+}
+
+// OnReconnectError 设置重连失败时的回调，delay 为下一次重连前的等待时间
+func (c *Client) OnReconnectError(fn func(attempt int, delay time.Duration, err error)) {
+	c.mu.Lock()
+	c.onReconnectError = fn
+	c.mu.Unlock()
+}
+
+// IsConnected 当前是否已连接
+func (c *Client) IsConnected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn != nil && !c.conn.IsClosed()
+}
+
+// connection 获取当前连接，断线重连期间等待重连成功或 ctx 结束
+func (c *Client) connection(ctx context.Context) (*amqp.Connection, error) {
+	for {
+		c.mu.RLock()
+		conn, ready := c.conn, c.ready
+		c.mu.RUnlock()
+		if conn != nil && !conn.IsClosed() {
+			return conn, nil
+		}
+		// 连接刚断开时 watch 可能还未重置 ready，稍后再检查
+		wait, retry := ready, (<-chan time.Time)(nil)
+		select {
+		case <-ready:
+			wait, retry = nil, time.After(50*time.Millisecond)
+		default:
+		}
+		select {
+		case <-wait:
+		case <-retry:
+		case <-c.closing:
+			return nil, ErrClientClosed
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s: %s", "Failed to wait for RabbitMQ connection", ctx.Err())
+		}
+	}
+}
+
+// channel 在当前连接上打开一个管道
+func (c *Client) channel(ctx context.Context) (*amqp.Channel, error) {
+	conn, err := c.connection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", "Failed to open a channel", err)
+	}
+	return ch, nil
+}
+
+// watch 监听连接关闭，异常关闭时按指数退避重连，closed 为发布连接前注册的关闭通知
+// 主动调用 Close 关闭连接时 NotifyClose 不会收到错误，此时退出监听。
+func (c *Client) watch(closed <-chan *amqp.Error) {
+	err, ok := <-closed
+	if !ok || err == nil {
+		select {
+		case <-c.closing:
+			return
+		default:
+		}
+		// 注册通知前连接已经断开时通知通道直接关闭，没有错误信息
+		err = amqp.ErrClosed
+	}
+
+	c.mu.Lock()
+	c.ready = make(chan struct{})
+	onDisconnect := c.onDisconnect
+	c.mu.Unlock()
+	log.Println("Connect closed,try reconnect,", err.Error())
+	c.connectionEvent(ConnectionEvent{State: StateDisconnected, Err: err})
+	if onDisconnect != nil {
+		onDisconnect(err)
+	}
+	c.reconnect()
+}
+
+// reconnect 按指数退避重连，直到成功
+func (c *Client) reconnect() {
+	delay := c.reconnectDelay()
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-c.closing:
+			return
+		default:
+		}
+		conn, err := c.dial()
+		if err == nil {
+			select {
+			case <-c.closing:
+				conn.Close()
+				return
+			default:
+			}
+			// 先恢复拓扑再唤醒等待的发布端与消费端，保证消费端恢复时队列与绑定已存在
+			c.redeclare(conn)
+			closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+			c.mu.Lock()
+			c.conn = conn
+			close(c.ready)
+			onReconnect := c.onReconnect
+			c.mu.Unlock()
+			log.Println("Try reconnect success")
+			c.connectionEvent(ConnectionEvent{State: StateReconnected, Attempt: attempt})
+			if onReconnect != nil {
+				onReconnect(attempt)
+			}
+			go c.watch(closed)
+			return
+		}
+
+		wait := c.jitter(delay)
+		c.mu.RLock()
+		onReconnectError := c.onReconnectError
+		c.mu.RUnlock()
+		log.Printf("Try reconnect fail,wait %s,%s\n", wait, err.Error())
+		c.connectionEvent(ConnectionEvent{State: StateReconnectFailed, Attempt: attempt, Delay: wait, Err: err})
+		if onReconnectError != nil {
+			onReconnectError(attempt, wait, err)
+		}
+		select {
+		case <-time.After(wait):
+		case <-c.closing:
+			return
+		}
+		delay = c.nextDelay(delay)
+	}
+}
+
+func (c *Client) reconnectDelay() time.Duration {
+	if c.opts.ReconnectDelay > 0 {
+		return time.Duration(c.opts.ReconnectDelay) * time.Second
+	}
+	return DefaultReconnectDelay * time.Second
+}
+
+func (c *Client) nextDelay(delay time.Duration) time.Duration {
+	max := time.Duration(DefaultReconnectMaxDelay) * time.Second
+	if c.opts.ReconnectMaxDelay > 0 {
+		max = time.Duration(c.opts.ReconnectMaxDelay) * time.Second
+	}
+	delay *= 2
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// random 使用客户端的随机源执行 fn
+// Go 1.20 之前全局随机源固定播种，每个进程得到相同的序列，所以使用按时间播种的独立随机源。
+func (c *Client) random(fn func(r *rand.Rand)) {
+	c.randMu.Lock()
+	defer c.randMu.Unlock()
+	if c.rand == nil {
+		c.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	fn(c.rand)
+}
+
+// jitter 在 [delay/2, delay) 之间取随机值，避免大量客户端同时重连
+func (c *Client) jitter(delay time.Duration) time.Duration {
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	c.random(func(r *rand.Rand) {
+		half += r.Int63n(half)
+	})
+	return time.Duration(half)
+}
+
+// redeclare 在新连接上重新声明已记录的交换机、队列与绑定
+// broker 重启后非持久化的拓扑会丢失，集群切换节点时拓扑也可能不存在，重新声明是幂等的。
+func (c *Client) redeclare(conn *amqp.Connection) {
+	var ch *amqp.Channel
+	run := func(name string, fn func(ch *amqp.Channel) error) {
+		if ch == nil {
+			var err error
+			if ch, err = conn.Channel(); err != nil {
+				log.Println("Redeclare", name, "fail,", err.Error())
+				return
+			}
+		}
+		if err := fn(ch); err != nil {
+			log.Println("Redeclare", name, "fail,", err.Error())
+			// 声明失败时 broker 会关闭管道
+			ch.Close()
+			ch = nil
+		}
+	}
+
+	c.topoMu.Lock()
+	exchanges := make([]Exchange, 0, len(c.exchanges))
+	for _, exchange := range c.exchanges {
+		exchanges = append(exchanges, exchange)
+	}
+	queues := make([]QueueConfig, 0, len(c.queues))
+	for _, queue := range c.queues {
+		queues = append(queues, queue)
+	}
+	bindings := make([]binding, 0, len(c.bindings))
+	for _, b := range c.bindings {
+		bindings = append(bindings, b)
+	}
+	c.topoMu.Unlock()
+
+	for _, exchange := range exchanges {
+		exchange := exchange
+		run("exchange "+exchange.Name, func(ch *amqp.Channel) error {
+			return exchangeDeclare(ch, exchange)
+		})
+	}
+	for _, queue := range queues {
+		queue := queue
+		run("queue "+queue.Name, func(ch *amqp.Channel) error {
+			_, err := declareQueueConfig(ch, queue)
+			return err
+		})
+	}
+	c.declared.Range(func(_, value interface{}) bool {
+		switch q := value.(type) {
+		case declaredQueue:
+			run("queue "+q.queue, func(ch *amqp.Channel) error {
+				_, err := c.queueDeclare(ch, q.queue, q.expire)
+				return err
+			})
+		case delayQueue:
+			run("queue "+delayQueueName(q.queue, q.delay), func(ch *amqp.Channel) error {
+				return declareDelayQueue(ch, q.queue, q.delay)
+			})
+		}
+		return true
+	})
+	for _, b := range bindings {
+		b := b
+		run("binding "+b.key(), func(ch *amqp.Channel) error {
+			return ch.QueueBind(b.queue, b.routingKey, b.exchange, false, b.args)
+		})
+	}
+	if ch != nil {
+		ch.Close()
+	}
+}
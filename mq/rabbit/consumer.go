@@ -0,0 +1,321 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/yiuked/gopkg/utils"
+)
+
+// DefaultShutdownTimeout 消费端停止时等待处理中消息的默认时间（秒）
+const DefaultShutdownTimeout = 30
+
+// ErrClientClosed 客户端已关闭
+var ErrClientClosed = errors.New("rabbit: client closed")
+
+// consumeOptions 消费端选项
+type consumeOptions struct {
+	prefetch    int
+	workers     int
+	ordered     bool
+	maxRetries  int
+	retryDelays []time.Duration
+	middlewares []Middleware
+	args        amqp.Table // 消费参数，如 x-stream-offset
+
+	batchSize int                                             // 批量消费时每批最多的消息数
+	batchWait time.Duration                                   // 批量消费时等待凑满一批的最长时间
+	batch     func(ctx context.Context, msgs []amqp.Delivery) // 批量处理，由 ConsumeBatch 设置
+}
+
+// ConsumeOption 消费端选项
+type ConsumeOption func(o *consumeOptions)
+
+// WithPrefetch 设置预取数量，即 broker 最多推送给该消费端的未确认消息数，默认1
+func WithPrefetch(n int) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.prefetch = n
+	}
+}
+
+// WithWorkers 设置同时执行 handler 的最大协程数，默认与预取数量相同
+// 大于预取数量时多出的协程不会被用到
+func WithWorkers(n int) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.workers = n
+	}
+}
+
+// WithOrdered 按投递顺序逐条处理消息，上一条处理完成后才处理下一条
+// 适用于对顺序有要求的队列，注意消息重新入队（nack/断线）后仍可能乱序
+func WithOrdered() ConsumeOption {
+	return func(o *consumeOptions) {
+		o.ordered = true
+	}
+}
+
+// WithRetry 设置处理失败后的重试策略
+// 处理失败的消息按重试次数依次投递到 delays 对应的延迟重试队列，到期后回到原队列，
+// 超过 maxRetries 次后进入死信队列 dead:<queue>。重试次数超过 delays 长度时使用最后一个延迟。
+// maxRetries 为0时不重试，失败直接进入死信队列。默认重试3次，延迟依次为1秒、10秒、1分钟
+func WithRetry(maxRetries int, delays ...time.Duration) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.maxRetries = maxRetries
+		if len(delays) > 0 {
+			o.retryDelays = delays
+		}
+	}
+}
+
+// WithStreamOffset 设置流队列的起始消费位置，只对 Consume 流队列生效
+// offset 可以是 "first"（最早的消息）、"last"（最后一个数据块）、"next"（只消费新消息，默认），
+// int64 偏移量，time.Time 时间点，或 "1D"、"12h" 这样的相对时间。
+// 流队列中的消息确认后不会删除，也不支持死信，消费流队列时建议同时使用 WithRetry(0)。
+func WithStreamOffset(offset interface{}) ConsumeOption {
+	return func(o *consumeOptions) {
+		switch v := offset.(type) {
+		case int:
+			offset = int64(v)
+		case time.Time:
+			// broker 要求时间戳类型为 AMQP timestamp，精度为秒
+			offset = v.Truncate(time.Second)
+		}
+		if o.args == nil {
+			o.args = amqp.Table{}
+		}
+		o.args["x-stream-offset"] = offset
+	}
+}
+
+// WithBatch 设置 ConsumeBatch 每批最多 size 条消息，收到第一条消息后最多等待 wait 凑满一批，默认100条、1秒
+// 预取数量小于 size 时按 size 预取，否则永远凑不满一批
+func WithBatch(size int, wait time.Duration) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.batchSize = size
+		o.batchWait = wait
+	}
+}
+
+// WithMiddleware 为 handler 添加中间件，按添加顺序由外到内执行
+func WithMiddleware(middlewares ...Middleware) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.middlewares = append(o.middlewares, middlewares...)
+	}
+}
+
+// wrap 使用中间件包装 handler
+func (o *consumeOptions) wrap(handler Handler) Handler {
+	for i := len(o.middlewares) - 1; i >= 0; i-- {
+		handler = o.middlewares[i](handler)
+	}
+	return handler
+}
+
+func newConsumeOptions(opts []ConsumeOption) *consumeOptions {
+	o := &consumeOptions{prefetch: 1, maxRetries: DefaultMaxRetries, retryDelays: DefaultRetryDelays}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.prefetch <= 0 {
+		o.prefetch = 1
+	}
+	if o.workers <= 0 {
+		o.workers = o.prefetch
+	}
+	if o.ordered {
+		o.workers = 1
+	}
+	return o
+}
+
+func (c *Client) shutdownTimeout() time.Duration {
+	if c.opts.ShutdownTimeout > 0 {
+		return time.Duration(c.opts.ShutdownTimeout) * time.Second
+	}
+	return DefaultShutdownTimeout * time.Second
+}
+
+// consume 持续消费，管道或连接断开后等待连接恢复，重新声明队列并订阅
+// setup 在新管道上完成声明并以 tag 作为消费者标签开始消费，首次 setup 失败时直接返回错误。
+// ctx 结束或客户端关闭时取消订阅，不再接收新消息，等待处理中的消息（最多 ShutdownTimeout）后关闭管道。
+func (c *Client) consume(ctx context.Context, o *consumeOptions, setup func(ch *amqp.Channel, tag string) (<-chan amqp.Delivery, error), process func(ctx context.Context, msg amqp.Delivery)) error {
+	if !c.addConsumer() {
+		return ErrClientClosed
+	}
+	defer c.consumers.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	tag := "ctag-" + utils.RandomString(16)
+	delay := c.reconnectDelay()
+	for first := true; ; first = false {
+		ch, err := c.channel(ctx)
+		var msgChan <-chan amqp.Delivery
+		if err == nil {
+			if err = ch.Qos(o.prefetch, 0, false); err != nil {
+				err = fmt.Errorf("%s: %s", "Failed to set channel Qos", err)
+			} else {
+				msgChan, err = setup(ch, tag)
+			}
+			if err != nil {
+				ch.Close()
+			}
+		}
+		if err != nil {
+			if first {
+				return err
+			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			wait := c.jitter(delay)
+			log.Printf("Try restore consumer fail,wait %s,%s\n", wait, err.Error())
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil
+			}
+			delay = c.nextDelay(delay)
+			continue
+		}
+		if !first {
+			log.Println("Try restore consumer success")
+		}
+		delay = c.reconnectDelay()
+
+		if c.dispatch(ctx, o, ch, tag, msgChan, process) {
+			return nil
+		}
+		log.Println("Consumer channel closed,try restore")
+	}
+}
+
+// dispatch 分发消息直到管道关闭或 ctx 结束，ctx 结束时完成优雅停止并返回 true
+// 同时执行 process 的协程数不超过 workers，顺序模式下在当前协程逐条处理。
+// process 收到的 ctx 不随 ctx 结束而取消，只在等待超时后取消，保证优雅停止期间处理中的消息可以完成。
+func (c *Client) dispatch(ctx context.Context, o *consumeOptions, ch *amqp.Channel, tag string, msgChan <-chan amqp.Delivery, process func(ctx context.Context, msg amqp.Delivery)) bool {
+	if o.batch != nil {
+		return c.dispatchBatch(ctx, o, ch, tag, msgChan)
+	}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, o.workers)
+	hctx, hcancel := context.WithCancel(detach(ctx))
+	defer hcancel()
+	defer closeChannel(ch)
+	for {
+		select {
+		case msg, ok := <-msgChan:
+			if !ok {
+				// 管道已关闭，处理中的消息无法再 ack，不需要等待
+				return false
+			}
+			if o.ordered {
+				process(hctx, msg)
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				// 未开始处理的消息在管道关闭后重新入队
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				process(hctx, msg)
+			}()
+		case <-ctx.Done():
+			// 取消订阅后 broker 不再投递新消息，已投递未处理的消息在管道关闭后重新入队
+			if err := ch.Cancel(tag, false); err != nil {
+				log.Println("cancel consumer err", err.Error())
+			}
+			done := make(chan struct{})
+			go func() {
+				wg.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(c.shutdownTimeout()):
+				log.Println("Wait consumer handlers timeout,", tag)
+			}
+			return true
+		}
+	}
+}
+
+// detachedContext 保留父 context 的值，但不随父 context 取消
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+func closeChannel(ch *amqp.Channel) {
+	if err := ch.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		log.Println("close channel err", err.Error())
+	}
+}
+
+// addConsumer 登记一个运行中的消费端，客户端已关闭时返回 false
+// 与 stopConsumers 持有同一把锁，保证开始等待消费端退出后不会再有新的消费端登记。
+func (c *Client) addConsumer() bool {
+	c.closeMu.Lock()
+	defer c.closeMu.Unlock()
+	select {
+	case <-c.closing:
+		return false
+	default:
+	}
+	c.consumers.Add(1)
+	return true
+}
+
+// stopConsumers 通知所有消费端停止并等待退出
+func (c *Client) stopConsumers() {
+	c.closeMu.Lock()
+	c.closeOnce.Do(func() {
+		close(c.closing)
+	})
+	c.closeMu.Unlock()
+	c.consumers.Wait()
+}
+
+// Close 关闭客户端
+// 停止所有消费端并等待处理中的消息完成（最多 ShutdownTimeout），然后关闭发布端管道与连接。
+func (c *Client) Close() error {
+	c.stopConsumers()
+	c.pool.close()
+	c.confirmPool.close()
+
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	if err := conn.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return fmt.Errorf("%s: %s", "Failed to close RabbitMQ connection", err)
+	}
+	c.connectionEvent(ConnectionEvent{State: StateClosed})
+	return nil
+}
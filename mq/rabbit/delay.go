@@ -0,0 +1,146 @@
+package rabbit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// DelayMode 延迟消息的实现方式
+type DelayMode string
+
+const (
+	// DelayTTL 每个延迟时间一个 TTL 队列，消息过期后通过死信路由到目标队列，不依赖插件
+	DelayTTL DelayMode = "ttl"
+	// DelayPlugin 使用 rabbitmq_delayed_message_exchange 插件提供的 x-delayed-message 交换机
+	DelayPlugin DelayMode = "plugin"
+
+	// DefaultDelayExchange plugin 模式下默认的延迟交换机名称
+	DefaultDelayExchange = "delayed"
+)
+
+// delayQueueName ttl 模式下的延迟队列名称，如 delay:order:60000
+func delayQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("delay:%s:%d", queue, delay.Milliseconds())
+}
+
+func (c *Client) delayExchange() string {
+	if c.opts.DelayExchange != "" {
+		return c.opts.DelayExchange
+	}
+	return DefaultDelayExchange
+}
+
+// PublishDelayed 发布延迟消息，消息在 delay 之后才投递到 queue
+// 实现方式由 Option.DelayMode 决定：
+// ttl 模式为每个延迟时间声明一个 delay:<queue>:<毫秒> 队列，消息过期后路由回 queue，
+// 延迟时间种类较多时会产生较多队列；plugin 模式需要 broker 安装 rabbitmq_delayed_message_exchange 插件。
+// 与 Publish 一样会声明 queue（不设置过期时间），并以确认模式等待 broker 确认。
+func (c *Client) PublishDelayed(queue string, body []byte, delay time.Duration, opts ...PublishOption) error {
+	return c.PublishDelayedCtx(context.Background(), queue, body, delay, opts...)
+}
+
+// PublishDelayedCtx 与 PublishDelayed 一致，ctx 中的链路信息会写入消息头，ctx 没有截止时间时最多等待 ConfirmTimeout
+func (c *Client) PublishDelayedCtx(ctx context.Context, queue string, body []byte, delay time.Duration, opts ...PublishOption) error {
+	ms := delay.Milliseconds()
+	msg := newPublishing(body, 0)
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	if ms <= 0 {
+		return c.publish(ctx, true, "", queue, true, msg, func(ch *amqp.Channel) error {
+			return c.ensureQueue(ch, queue, 0)
+		})
+	}
+	if c.opts.DelayMode == DelayPlugin {
+		WithHeaders(amqp.Table{"x-delay": ms})(&msg)
+		// 延迟交换机在消息到期前无法判断是否可路由，不能使用 mandatory
+		return c.publish(ctx, true, c.delayExchange(), queue, false, msg, func(ch *amqp.Channel) error {
+			if err := c.ensureQueue(ch, queue, 0); err != nil {
+				return err
+			}
+			return c.ensureDelayExchange(ch, queue)
+		})
+	}
+
+	// 队列与消息都设置过期时间，消息过期后才会被死信路由
+	msg.Expiration = strconv.FormatInt(ms, 10)
+	return c.publish(ctx, true, "", delayQueueName(queue, delay), true, msg, func(ch *amqp.Channel) error {
+		if err := c.ensureQueue(ch, queue, 0); err != nil {
+			return err
+		}
+		return c.ensureDelayQueue(ch, queue, delay)
+	})
+}
+
+// delayQueue ttl 模式下已声明的延迟队列，断线重连后重新声明
+type delayQueue struct {
+	queue string
+	delay time.Duration
+}
+
+// ensureDelayQueue 声明 ttl 模式的延迟队列，声明结果缓存在 Client 中
+func (c *Client) ensureDelayQueue(ch *amqp.Channel, queue string, delay time.Duration) error {
+	name := delayQueueName(queue, delay)
+	if _, ok := c.declared.Load(name); ok {
+		return nil
+	}
+	if err := declareDelayQueue(ch, queue, delay); err != nil {
+		return err
+	}
+	c.declared.Store(name, delayQueue{queue: queue, delay: delay})
+	return nil
+}
+
+func declareDelayQueue(ch *amqp.Channel, queue string, delay time.Duration) error {
+	_, err := ch.QueueDeclare(
+		delayQueueName(queue, delay),
+		true,
+		false,
+		false,
+		false,
+		amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": queue,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare a delay queue: %v", err)
+	}
+	return nil
+}
+
+// ensureDelayExchange 声明 plugin 模式的延迟交换机并绑定队列，并记录以便断线重连后恢复
+func (c *Client) ensureDelayExchange(ch *amqp.Channel, queue string) error {
+	exchange := Exchange{
+		Name:    c.delayExchange(),
+		Kind:    "x-delayed-message",
+		Durable: true,
+		Args:    amqp.Table{"x-delayed-type": string(ExchangeDirect)},
+	}
+	b := binding{queue: queue, exchange: exchange.Name, routingKey: queue}
+
+	c.topoMu.Lock()
+	_, declared := c.exchanges[exchange.Name]
+	_, bound := c.bindings[b.key()]
+	c.topoMu.Unlock()
+	if declared && bound {
+		return nil
+	}
+
+	if err := exchangeDeclare(ch, exchange); err != nil {
+		return err
+	}
+	if err := ch.QueueBind(queue, queue, exchange.Name, false, nil); err != nil {
+		return fmt.Errorf("%s: %s", "Failed to bind a queue", err)
+	}
+	c.topoMu.Lock()
+	c.exchanges[exchange.Name] = exchange
+	c.bindings[b.key()] = b
+	c.topoMu.Unlock()
+	return nil
+}
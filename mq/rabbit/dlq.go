@@ -0,0 +1,215 @@
+package rabbit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/yiuked/gopkg/mq"
+)
+
+// DeadQueueName 队列对应的死信队列名称
+func DeadQueueName(queue string) string {
+	return mq.DeadQueueName(queue)
+}
+
+// Death 消息的一次死信记录，来自 broker 写入的 x-death 消息头
+type Death struct {
+	Queue       string    // 消息成为死信时所在的队列
+	Reason      string    // 原因：rejected（被拒绝）、expired（过期）、maxlen（超出队列长度）、delivery_limit（超出投递次数）
+	Exchange    string    // 消息成为死信前发布到的交换机
+	RoutingKeys []string  // 消息成为死信前的 routing key
+	Count       int64     // 同一队列同一原因成为死信的次数
+	Time        time.Time // 首次成为死信的时间
+}
+
+// DeadLetter 死信队列中的消息
+type DeadLetter struct {
+	amqp.Delivery
+	Deaths []Death // 死信记录，最近一次在前
+}
+
+// Reason 最近一次成为死信的原因
+func (d *DeadLetter) Reason() string {
+	if len(d.Deaths) == 0 {
+		return ""
+	}
+	return d.Deaths[0].Reason
+}
+
+// OriginalQueue 最近一次成为死信时所在的队列
+func (d *DeadLetter) OriginalQueue() string {
+	if len(d.Deaths) == 0 {
+		return ""
+	}
+	return d.Deaths[0].Queue
+}
+
+// Count 成为死信的总次数
+func (d *DeadLetter) Count() int64 {
+	var count int64
+	for _, death := range d.Deaths {
+		count += death.Count
+	}
+	return count
+}
+
+// parseDeaths 解析 x-death 消息头
+func parseDeaths(headers amqp.Table) []Death {
+	list, ok := headers["x-death"].([]interface{})
+	if !ok {
+		return nil
+	}
+	deaths := make([]Death, 0, len(list))
+	for _, item := range list {
+		table, ok := item.(amqp.Table)
+		if !ok {
+			continue
+		}
+		death := Death{}
+		death.Queue, _ = table["queue"].(string)
+		death.Reason, _ = table["reason"].(string)
+		death.Exchange, _ = table["exchange"].(string)
+		death.Count = int64(headerInt(table, "count"))
+		death.Time, _ = table["time"].(time.Time)
+		if keys, ok := table["routing-keys"].([]interface{}); ok {
+			for _, key := range keys {
+				if key, ok := key.(string); ok {
+					death.RoutingKeys = append(death.RoutingKeys, key)
+				}
+			}
+		}
+		deaths = append(deaths, death)
+	}
+	return deaths
+}
+
+// PeekDeadLetters 查看 queue 对应死信队列中的前 limit 条消息，不会移除消息
+// 通过 basic.get 取出消息但不确认，查看结束关闭管道后消息重新入队，
+// 查看期间这些消息对其它消费者不可见，且重新入队后 Redelivered 标记为 true。
+// 断线重连期间等待连接直到 ctx 结束。
+func (c *Client) PeekDeadLetters(ctx context.Context, queue string, limit int) ([]DeadLetter, error) {
+	var letters []DeadLetter
+	err := c.withChannelCtx(ctx, func(ch *amqp.Channel) error {
+		q, err := ch.QueueInspect(DeadQueueName(queue))
+		if err != nil {
+			return fmt.Errorf("%s: %s", "Failed to inspect a dead-letter queue", err)
+		}
+		if limit <= 0 || limit > q.Messages {
+			limit = q.Messages
+		}
+		for i := 0; i < limit; i++ {
+			d, ok, err := ch.Get(q.Name, false)
+			if err != nil {
+				return fmt.Errorf("%s: %s", "Failed to get a dead letter", err)
+			}
+			if !ok {
+				break
+			}
+			letters = append(letters, DeadLetter{Delivery: d, Deaths: parseDeaths(d.Headers)})
+		}
+		return nil
+	})
+	return letters, err
+}
+
+// ReplayOptions 死信重放选项
+type ReplayOptions struct {
+	Filter func(d *DeadLetter) bool // 只重放返回 true 的消息，为 nil 时重放全部
+	Limit  int                      // 最多重放的消息数，0 表示不限制
+	Rate   int                      // 每秒最多重放的消息数，0 表示不限速
+	Target string                   // 重放到的队列，为空时重放回原队列 queue
+}
+
+// ReplayResult 死信重放结果
+type ReplayResult struct {
+	Replayed int // 已重放并从死信队列移除的消息数
+	Skipped  int // 不满足过滤条件，保留在死信队列中的消息数
+}
+
+// ReplayDeadLetters 将 queue 对应死信队列中的消息重新投递到原队列
+// 重放时清除 x-death 与 x-retry-count 消息头，以确认模式发布成功后才从死信队列移除。
+// 只处理开始时死信队列中已有的消息，不满足过滤条件的消息在结束后重新入队。
+func (c *Client) ReplayDeadLetters(ctx context.Context, queue string, opts ReplayOptions) (ReplayResult, error) {
+	var result ReplayResult
+	target := opts.Target
+	if target == "" {
+		target = queue
+	}
+	var tick <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(opts.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	err := c.withChannelCtx(ctx, func(ch *amqp.Channel) error {
+		q, err := ch.QueueInspect(DeadQueueName(queue))
+		if err != nil {
+			return fmt.Errorf("%s: %s", "Failed to inspect a dead-letter queue", err)
+		}
+		for i := 0; i < q.Messages; i++ {
+			if opts.Limit > 0 && result.Replayed >= opts.Limit {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			d, ok, err := ch.Get(q.Name, false)
+			if err != nil {
+				return fmt.Errorf("%s: %s", "Failed to get a dead letter", err)
+			}
+			if !ok {
+				return nil
+			}
+			letter := &DeadLetter{Delivery: d, Deaths: parseDeaths(d.Headers)}
+			if opts.Filter != nil && !opts.Filter(letter) {
+				// 不确认，管道关闭后重新入队
+				result.Skipped++
+				continue
+			}
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			msg := copyPublishing(d)
+			for key := range msg.Headers {
+				if key == "x-death" || key == HeaderRetryCount || strings.HasPrefix(key, "x-first-death-") || strings.HasPrefix(key, "x-last-death-") {
+					delete(msg.Headers, key)
+				}
+			}
+			pctx, cancel := context.WithTimeout(ctx, c.confirmTimeout())
+			err = c.confirmPublish(pctx, "", target, msg)
+			cancel()
+			if err != nil {
+				return err
+			}
+			if err := d.Ack(false); err != nil {
+				return fmt.Errorf("%s: %s", "Failed to ack a dead letter", err)
+			}
+			result.Replayed++
+		}
+		return nil
+	})
+	return result, err
+}
+
+// PurgeDeadLetters 清空 queue 对应的死信队列，返回清除的消息数，断线重连期间等待连接直到 ctx 结束
+func (c *Client) PurgeDeadLetters(ctx context.Context, queue string) (int, error) {
+	var count int
+	err := c.withChannelCtx(ctx, func(ch *amqp.Channel) error {
+		var err error
+		count, err = ch.QueuePurge(DeadQueueName(queue), false)
+		if err != nil {
+			return fmt.Errorf("%s: %s", "Failed to purge a dead-letter queue", err)
+		}
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,176 @@
+package rabbit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Codec 消息体编解码器
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec JSON 编解码器，默认使用
+type JSONCodec struct{}
+
+func (JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// SetCodec 设置 Publish[T]/Consume[T] 使用的编解码器，默认 JSONCodec
+func (c *Client) SetCodec(codec Codec) {
+	c.mu.Lock()
+	c.codec = codec
+	c.mu.Unlock()
+}
+
+func (c *Client) getCodec() Codec {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.codec == nil {
+		return JSONCodec{}
+	}
+	return c.codec
+}
+
+// Meta 消息元数据
+type Meta struct {
+	MessageID     string
+	CorrelationID string
+	Type          string
+	Timestamp     time.Time
+	Headers       amqp.Table
+	Queue         string // 消费的队列名称
+	RetryCount    int    // 已重试次数
+	Redelivered   bool   // 是否为重新投递的消息
+}
+
+// Meta 消息元数据
+func (m *Message) Meta() Meta {
+	return Meta{
+		MessageID:     m.MessageId,
+		CorrelationID: m.CorrelationId,
+		Type:          m.Type,
+		Timestamp:     m.Timestamp,
+		Headers:       m.Headers,
+		Queue:         m.Queue,
+		RetryCount:    m.RetryCount(),
+		Redelivered:   m.Redelivered,
+	}
+}
+
+// PublishOption 发布选项，用于设置消息属性
+type PublishOption func(msg *amqp.Publishing)
+
+// WithMessageID 设置消息ID，默认随机生成
+func WithMessageID(id string) PublishOption {
+	return func(msg *amqp.Publishing) {
+		msg.MessageId = id
+	}
+}
+
+// WithCorrelationID 设置关联ID，用于关联请求与响应或同一业务流程的消息
+func WithCorrelationID(id string) PublishOption {
+	return func(msg *amqp.Publishing) {
+		msg.CorrelationId = id
+	}
+}
+
+// WithType 设置消息类型，默认为值的 Go 类型名称，如 model.Order
+func WithType(typ string) PublishOption {
+	return func(msg *amqp.Publishing) {
+		msg.Type = typ
+	}
+}
+
+// WithPriority 设置消息优先级，队列需要设置 QueueConfig.MaxPriority，超过最大优先级时按最大优先级处理
+func WithPriority(priority uint8) PublishOption {
+	return func(msg *amqp.Publishing) {
+		msg.Priority = priority
+	}
+}
+
+// WithHeaders 设置自定义消息头
+func WithHeaders(headers amqp.Table) PublishOption {
+	return func(msg *amqp.Publishing) {
+		if msg.Headers == nil {
+			msg.Headers = amqp.Table{}
+		}
+		for k, v := range headers {
+			msg.Headers[k] = v
+		}
+	}
+}
+
+// NewMessageID 生成随机消息ID
+func NewMessageID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewEnvelope 使用客户端的编解码器编码 v，并设置消息ID、时间、类型等属性
+func (c *Client) NewEnvelope(v interface{}, opts ...PublishOption) (amqp.Publishing, error) {
+	codec := c.getCodec()
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return amqp.Publishing{}, fmt.Errorf("%s: %s", "Failed to encode a message", err)
+	}
+	msg := amqp.Publishing{
+		ContentType:  codec.ContentType(),
+		DeliveryMode: amqp.Persistent,
+		MessageId:    NewMessageID(),
+		Timestamp:    time.Now(),
+		Type:         fmt.Sprintf("%T", v),
+		Body:         body,
+	}
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	return msg, nil
+}
+
+// PublishMessage 以确认模式发布完整的消息到 queue，与 Publish 一样会声明 queue（不设置过期时间）
+func (c *Client) PublishMessage(ctx context.Context, queue string, msg amqp.Publishing) error {
+	return c.publish(ctx, true, "", queue, true, msg, func(ch *amqp.Channel) error {
+		return c.ensureQueue(ch, queue, 0)
+	})
+}
+
+// Publish 编码 v 并以确认模式发布到 queue
+func Publish[T any](ctx context.Context, c *Client, queue string, v T, opts ...PublishOption) error {
+	msg, err := c.NewEnvelope(v, opts...)
+	if err != nil {
+		return err
+	}
+	return c.PublishMessage(ctx, queue, msg)
+}
+
+// Consume 消费 queue 并将消息体解码为 T 后交给 handler，其它行为与 Client.Consume 一致
+// 解码失败视为处理失败，按重试策略处理
+func Consume[T any](ctx context.Context, c *Client, queue string, handler func(ctx context.Context, v T, meta Meta) error, opts ...ConsumeOption) error {
+	codec := c.getCodec()
+	return c.Consume(ctx, queue, 0, func(ctx context.Context, msg *Message) error {
+		var v T
+		if err := codec.Unmarshal(msg.Body, &v); err != nil {
+			return fmt.Errorf("%s: %s", "Failed to decode a message", err)
+		}
+		return handler(ctx, v, msg.Meta())
+	}, opts...)
+}
@@ -0,0 +1,223 @@
+package rabbit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// ExchangeKind 交换机类型
+type ExchangeKind string
+
+const (
+	ExchangeDirect  ExchangeKind = amqp.ExchangeDirect  // 按 routing key 精确匹配
+	ExchangeFanout  ExchangeKind = amqp.ExchangeFanout  // 广播到所有绑定的队列，忽略 routing key
+	ExchangeTopic   ExchangeKind = amqp.ExchangeTopic   // 按 routing key 通配符匹配（* 匹配一个单词，# 匹配零个或多个单词）
+	ExchangeHeaders ExchangeKind = amqp.ExchangeHeaders // 按消息头匹配，忽略 routing key
+)
+
+// Exchange 交换机声明参数
+type Exchange struct {
+	Name       string       `mapstructure:"name" json:"name" yaml:"name"`
+	Kind       ExchangeKind `mapstructure:"kind" json:"kind" yaml:"kind"`
+	Durable    bool         `mapstructure:"durable" json:"durable" yaml:"durable"`
+	AutoDelete bool         `mapstructure:"auto_delete" json:"auto_delete" yaml:"auto_delete"`
+	Internal   bool         `mapstructure:"internal" json:"internal" yaml:"internal"` // 内部交换机只能由其它交换机路由，客户端不能直接发布
+	Args       amqp.Table   `mapstructure:"args" json:"args" yaml:"args"`
+}
+
+// withChannel 打开一个临时管道执行 fn，执行完成后关闭
+func (c *Client) withChannel(fn func(ch *amqp.Channel) error) error {
+	return c.withChannelCtx(context.Background(), fn)
+}
+
+// withChannelCtx 与 withChannel 一致，断线重连期间等待连接直到 ctx 结束
+func (c *Client) withChannelCtx(ctx context.Context, fn func(ch *amqp.Channel) error) error {
+	ch, err := c.channel(ctx)
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	return fn(ch)
+}
+
+// DeclareExchange 声明交换机，与队列一样，已存在的同名交换机参数必须一致，否则会出现声明冲突
+// 声明成功的交换机会被记录，断线重连后自动重新声明
+func (c *Client) DeclareExchange(exchange Exchange) error {
+	if exchange.Kind == "" {
+		exchange.Kind = ExchangeDirect
+	}
+	err := c.withChannel(func(ch *amqp.Channel) error {
+		return exchangeDeclare(ch, exchange)
+	})
+	if err != nil {
+		return err
+	}
+	c.topoMu.Lock()
+	c.exchanges[exchange.Name] = exchange
+	c.topoMu.Unlock()
+	return nil
+}
+
+func exchangeDeclare(ch *amqp.Channel, exchange Exchange) error {
+	err := ch.ExchangeDeclare(
+		exchange.Name,
+		string(exchange.Kind),
+		exchange.Durable,
+		exchange.AutoDelete,
+		exchange.Internal,
+		false, // no-wait
+		exchange.Args,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %s", "Failed to declare an exchange", err)
+	}
+	return nil
+}
+
+// DeleteExchange 删除交换机，ifUnused 为 true 时交换机仍有绑定则删除失败
+func (c *Client) DeleteExchange(exchange string, ifUnused bool) error {
+	err := c.withChannel(func(ch *amqp.Channel) error {
+		if err := ch.ExchangeDelete(exchange, ifUnused, false); err != nil {
+			return fmt.Errorf("%s: %s", "Failed to delete an exchange", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.topoMu.Lock()
+	delete(c.exchanges, exchange)
+	for key, b := range c.bindings {
+		if b.exchange == exchange {
+			delete(c.bindings, key)
+		}
+	}
+	c.topoMu.Unlock()
+	return nil
+}
+
+// BindQueue 将队列绑定到交换机
+// direct/topic 交换机按 routingKey 匹配，fanout 交换机忽略 routingKey。
+// 队列需要先声明（例如调用过 Publish 或 Consume），否则绑定失败。
+func (c *Client) BindQueue(queue, exchange, routingKey string) error {
+	return c.bindQueue(queue, exchange, routingKey, nil)
+}
+
+// BindQueueHeaders 将队列以消息头匹配的方式绑定到 headers 交换机
+// matchAll 为 true 时要求所有消息头都匹配（x-match=all），否则匹配任意一个即可（x-match=any）
+func (c *Client) BindQueueHeaders(queue, exchange string, headers amqp.Table, matchAll bool) error {
+	args := amqp.Table{}
+	for k, v := range headers {
+		args[k] = v
+	}
+	if matchAll {
+		args["x-match"] = "all"
+	} else {
+		args["x-match"] = "any"
+	}
+	return c.bindQueue(queue, exchange, "", args)
+}
+
+func (c *Client) bindQueue(queue, exchange, routingKey string, args amqp.Table) error {
+	err := c.withChannel(func(ch *amqp.Channel) error {
+		if err := ch.QueueBind(queue, routingKey, exchange, false, args); err != nil {
+			return fmt.Errorf("%s: %s", "Failed to bind a queue", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b := binding{queue: queue, exchange: exchange, routingKey: routingKey, args: args}
+	c.topoMu.Lock()
+	c.bindings[b.key()] = b
+	c.topoMu.Unlock()
+	return nil
+}
+
+// UnbindQueue 解除队列与交换机的绑定，headers 交换机需要传入绑定时的 args
+func (c *Client) UnbindQueue(queue, exchange, routingKey string, args amqp.Table) error {
+	err := c.withChannel(func(ch *amqp.Channel) error {
+		if err := ch.QueueUnbind(queue, routingKey, exchange, args); err != nil {
+			return fmt.Errorf("%s: %s", "Failed to unbind a queue", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b := binding{queue: queue, exchange: exchange, routingKey: routingKey, args: args}
+	c.topoMu.Lock()
+	delete(c.bindings, b.key())
+	c.topoMu.Unlock()
+	return nil
+}
+
+// PublishExchange 发布信息到指定交换机
+// routingKey 路由键，fanout 交换机会忽略该值，headers 交换机按 headers 匹配
+// headers 消息头，可为 nil
+// opts 发布选项，用于设置消息ID、关联ID等属性
+func (c *Client) PublishExchange(exchange, routingKey string, body []byte, headers amqp.Table, opts ...PublishOption) error {
+	return c.PublishExchangeCtx(context.Background(), exchange, routingKey, body, headers, opts...)
+}
+
+// PublishExchangeCtx 与 PublishExchange 一致，ctx 中的链路信息会写入消息头，ctx 没有截止时间时最多等待 ConfirmTimeout
+func (c *Client) PublishExchangeCtx(ctx context.Context, exchange, routingKey string, body []byte, headers amqp.Table, opts ...PublishOption) error {
+	msg := newPublishing(body, 0)
+	msg.Headers = headers
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	return c.publish(ctx, false, exchange, routingKey, false, msg, nil)
+}
+
+// Subscribe 广播订阅
+// 声明一个由服务端命名的排他队列（连接断开后自动删除），按 routingKeys 绑定到 exchange 后开始消费，
+// 每个订阅者都有自己的队列，因此 fanout/topic 交换机上的每条消息都会被每个订阅者收到。
+// 断线重连后会重新声明队列并绑定，断线期间的消息不会保留；ctx 结束时停止订阅。
+// routingKeys 为空时以空 routing key 绑定（适用于 fanout 交换机）
+// handler 处理函数，返回 nil 时自动 ack，返回错误或 panic 时丢弃消息（临时队列不做重试与死信）
+// opts 消费端选项，同 Consume，重试策略不生效
+func (c *Client) Subscribe(ctx context.Context, exchange string, routingKeys []string, handler Handler, opts ...ConsumeOption) error {
+	if len(routingKeys) == 0 {
+		routingKeys = []string{""}
+	}
+	o := newConsumeOptions(opts)
+	handler = o.wrap(handler)
+	return c.consume(ctx, o, func(ch *amqp.Channel, tag string) (<-chan amqp.Delivery, error) {
+		q, err := ch.QueueDeclare(
+			"",    // name，为空时由服务端生成唯一名称
+			false, // durable
+			true,  // delete when unused
+			true,  // exclusive
+			false, // no-wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", "Failed to declare a queue", err)
+		}
+		for _, key := range routingKeys {
+			if err := ch.QueueBind(q.Name, key, exchange, false, nil); err != nil {
+				return nil, fmt.Errorf("%s: %s", "Failed to bind a queue", err)
+			}
+		}
+
+		msgChan, err := ch.Consume(
+			q.Name, // queue
+			tag,    // consumer
+			false,  // auto-ack
+			true,   // exclusive
+			false,  // no-local
+			false,  // no-wait
+			nil,    // args
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", "Failed to consume a queue", err)
+		}
+		return msgChan, nil
+	}, func(ctx context.Context, msg amqp.Delivery) {
+		c.handle(ctx, o, "", msg, handler)
+	})
+}
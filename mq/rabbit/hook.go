@@ -0,0 +1,168 @@
+package rabbit
+
+import (
+	"context"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ConnectionState 连接状态
+type ConnectionState string
+
+const (
+	StateDisconnected    ConnectionState = "disconnected"     // 连接异常断开
+	StateReconnected     ConnectionState = "reconnected"      // 重连成功
+	StateReconnectFailed ConnectionState = "reconnect_failed" // 一次重连失败
+	StateClosed          ConnectionState = "closed"           // 调用 Close 关闭
+)
+
+// ConsumeResult 消息处理结果
+type ConsumeResult string
+
+const (
+	ResultAck        ConsumeResult = "ack"         // 处理成功并确认
+	ResultRetry      ConsumeResult = "retry"       // 处理失败，已投递到延迟重试队列
+	ResultDeadLetter ConsumeResult = "dead_letter" // 处理失败且不再重试，进入死信队列（广播订阅时丢弃）
+	ResultRequeue    ConsumeResult = "requeue"     // 投递重试队列失败，重新入队
+	ResultManual     ConsumeResult = "manual"      // handler 中已手动确认或拒绝
+)
+
+// ConnectionEvent 连接状态变化
+type ConnectionEvent struct {
+	State   ConnectionState
+	Attempt int           // 本次断线后的重连次数，重连相关状态有效
+	Delay   time.Duration // 下一次重连前的等待时间，StateReconnectFailed 有效
+	Err     error         // 断开或重连失败的原因
+}
+
+// PublishEvent 一次发布
+type PublishEvent struct {
+	Exchange       string
+	RoutingKey     string
+	MessageID      string
+	Size           int           // 消息体字节数
+	Confirm        bool          // 是否以确认模式发布
+	Duration       time.Duration // 发布总耗时，包含等待可用管道与确认，PublishDone 有效
+	ConfirmLatency time.Duration // 从发出到收到确认的耗时，确认模式且已发出时有效
+	Err            error         // 发布结果，PublishDone 有效
+	Headers        amqp.Table    // 实际发送的消息头，包含注入的链路信息，PublishDone 有效
+}
+
+// ConsumeEvent 一条消息的处理
+type ConsumeEvent struct {
+	Queue       string // 广播订阅时为空
+	Exchange    string
+	RoutingKey  string
+	MessageID   string
+	RetryCount  int
+	Redelivered bool
+	Duration    time.Duration // handler 耗时，ConsumeDone 有效
+	Err         error         // handler 返回的错误，ConsumeDone 有效
+	Result      ConsumeResult // 处理结果，ConsumeDone 有效
+}
+
+// Hook 观测回调，用于接入指标、日志与链路追踪
+// 回调在发布与消费的调用路径上同步执行，实现需要并发安全且尽快返回。
+// PublishStart/ConsumeStart 返回的 ctx 用于后续的发布与 handler，可在其中开始一个 span，
+// 并通过 ContextWithTrace 设置要传递的链路信息；消费时 ctx 中已有从消息头解析的链路信息（TraceFromContext）。
+type Hook interface {
+	Connection(e ConnectionEvent)
+	PublishStart(ctx context.Context, e *PublishEvent) context.Context
+	PublishDone(ctx context.Context, e *PublishEvent)
+	ConsumeStart(ctx context.Context, e *ConsumeEvent) context.Context
+	ConsumeDone(ctx context.Context, e *ConsumeEvent)
+}
+
+// NopHook 空实现，可嵌入自定义 Hook 中只实现关心的回调
+type NopHook struct{}
+
+func (NopHook) Connection(ConnectionEvent) {}
+
+func (NopHook) PublishStart(ctx context.Context, _ *PublishEvent) context.Context { return ctx }
+
+func (NopHook) PublishDone(context.Context, *PublishEvent) {}
+
+func (NopHook) ConsumeStart(ctx context.Context, _ *ConsumeEvent) context.Context { return ctx }
+
+func (NopHook) ConsumeDone(context.Context, *ConsumeEvent) {}
+
+// MultiHook 组合多个 Hook，Start 按顺序调用，Done 按相反顺序调用
+func MultiHook(hooks ...Hook) Hook {
+	return multiHook(hooks)
+}
+
+type multiHook []Hook
+
+func (m multiHook) Connection(e ConnectionEvent) {
+	for _, h := range m {
+		h.Connection(e)
+	}
+}
+
+func (m multiHook) PublishStart(ctx context.Context, e *PublishEvent) context.Context {
+	for _, h := range m {
+		ctx = h.PublishStart(ctx, e)
+	}
+	return ctx
+}
+
+func (m multiHook) PublishDone(ctx context.Context, e *PublishEvent) {
+	for i := len(m) - 1; i >= 0; i-- {
+		m[i].PublishDone(ctx, e)
+	}
+}
+
+func (m multiHook) ConsumeStart(ctx context.Context, e *ConsumeEvent) context.Context {
+	for _, h := range m {
+		ctx = h.ConsumeStart(ctx, e)
+	}
+	return ctx
+}
+
+func (m multiHook) ConsumeDone(ctx context.Context, e *ConsumeEvent) {
+	for i := len(m) - 1; i >= 0; i-- {
+		m[i].ConsumeDone(ctx, e)
+	}
+}
+
+// SetHook 设置观测回调，nil 表示不观测
+func (c *Client) SetHook(hook Hook) {
+	c.mu.Lock()
+	c.hook = hook
+	c.mu.Unlock()
+}
+
+func (c *Client) getHook() Hook {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.hook == nil {
+		return NopHook{}
+	}
+	return c.hook
+}
+
+func (c *Client) connectionEvent(e ConnectionEvent) {
+	c.getHook().Connection(e)
+}
+
+// observePublish 执行一次发布：通知 Hook，向消息头注入链路信息，记录耗时与结果
+// publish 负责实际发布，确认模式下需设置 e.ConfirmLatency。
+func (c *Client) observePublish(ctx context.Context, exchange, routingKey string, confirm bool, msg amqp.Publishing, publish func(msg amqp.Publishing, e *PublishEvent) error) error {
+	hook := c.getHook()
+	e := &PublishEvent{
+		Exchange:   exchange,
+		RoutingKey: routingKey,
+		MessageID:  msg.MessageId,
+		Size:       len(msg.Body),
+		Confirm:    confirm,
+	}
+	ctx = hook.PublishStart(ctx, e)
+	injectTrace(ctx, &msg)
+	e.Headers = msg.Headers
+	start := time.Now()
+	e.Err = publish(msg, e)
+	e.Duration = time.Since(start)
+	hook.PublishDone(ctx, e)
+	return e.Err
+}
@@ -0,0 +1,90 @@
+package rabbit
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/streadway/amqp"
+)
+
+// HeaderRetryCount 消息已重试次数
+const HeaderRetryCount = "x-retry-count"
+
+// Handler 消费端处理函数，返回 nil 时自动 ack，返回错误时按重试策略处理
+type Handler func(ctx context.Context, msg *Message) error
+
+// Middleware 消费端中间件，用于在 handler 前后增加通用逻辑，如去重、日志
+type Middleware func(next Handler) Handler
+
+// Message 消费端收到的消息
+type Message struct {
+	amqp.Delivery
+	Queue   string // 消费的队列名称，广播订阅时为空
+	settled int32
+}
+
+// Ack 手动确认消息，之后不再自动确认
+func (m *Message) Ack() error {
+	atomic.StoreInt32(&m.settled, 1)
+	return m.Delivery.Ack(false)
+}
+
+// Nack 手动拒绝消息，requeue 为 false 时进入死信队列（如果配置了），之后不再自动处理
+func (m *Message) Nack(requeue bool) error {
+	atomic.StoreInt32(&m.settled, 1)
+	return m.Delivery.Nack(false, requeue)
+}
+
+// Reject 手动拒绝消息，同 Nack
+func (m *Message) Reject(requeue bool) error {
+	atomic.StoreInt32(&m.settled, 1)
+	return m.Delivery.Reject(requeue)
+}
+
+// Settled 消息是否已确认或拒绝
+func (m *Message) Settled() bool {
+	return atomic.LoadInt32(&m.settled) == 1
+}
+
+// RetryCount 消息已重试的次数
+func (m *Message) RetryCount() int {
+	return headerInt(m.Headers, HeaderRetryCount)
+}
+
+// headerInt 读取整数类型的消息头，不存在或类型不符时返回0
+func headerInt(headers amqp.Table, key string) int {
+	switch v := headers[key].(type) {
+	case int:
+		return v
+	case int8:
+		return int(v)
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case uint8:
+		return int(v)
+	case uint16:
+		return int(v)
+	case uint32:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+// call 执行 handler，panic 时转换为错误返回
+func call(ctx context.Context, handler Handler, msg *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			err = fmt.Errorf("rabbit: handler panic recovered: %v\n%s", r, buf)
+		}
+	}()
+	return handler(ctx, msg)
+}
@@ -0,0 +1,53 @@
+package rabbit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/yiuked/gopkg/mq"
+)
+
+var _ mq.Broker = (*Client)(nil)
+
+// Send 实现 mq.Publisher，以确认模式发布消息到 queue
+func (c *Client) Send(ctx context.Context, queue string, msg *mq.Message) error {
+	pub := amqp.Publishing{
+		ContentType:   "application/octet-stream",
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     msg.ID,
+		CorrelationId: msg.CorrelationID,
+		Type:          msg.Type,
+		Timestamp:     msg.Timestamp,
+		Headers:       amqp.Table(msg.Headers),
+		Body:          msg.Body,
+	}
+	if pub.MessageId == "" {
+		pub.MessageId = NewMessageID()
+	}
+	if pub.Timestamp.IsZero() {
+		pub.Timestamp = time.Now()
+	}
+	if msg.TTL > 0 {
+		pub.Expiration = strconv.FormatInt(msg.TTL.Milliseconds(), 10)
+	}
+	return c.PublishMessage(ctx, queue, pub)
+}
+
+// Listen 实现 mq.Subscriber，行为与 Consume 一致
+func (c *Client) Listen(ctx context.Context, queue string, handler mq.Handler) error {
+	return c.Consume(ctx, queue, 0, func(ctx context.Context, msg *Message) error {
+		return handler(ctx, &mq.Message{
+			ID:            msg.MessageId,
+			CorrelationID: msg.CorrelationId,
+			Type:          msg.Type,
+			Timestamp:     msg.Timestamp,
+			Headers:       msg.Headers,
+			Body:          msg.Body,
+			Queue:         msg.Queue,
+			Redelivered:   msg.Redelivered,
+			RetryCount:    msg.RetryCount(),
+		})
+	})
+}
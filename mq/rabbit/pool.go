@@ -0,0 +1,131 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// DefaultPoolSize 发布端管道池默认大小
+const DefaultPoolSize = 8
+
+// pooledChannel 池中的管道，确认模式的管道同时持有确认与退回通知
+type pooledChannel struct {
+	ch       *amqp.Channel
+	closed   chan *amqp.Error
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+// isClosed 管道是否已被关闭（主动关闭、broker 关闭或连接断开）
+func (pc *pooledChannel) isClosed() bool {
+	select {
+	case <-pc.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// channelPool 有界的发布端管道池，并发安全
+// 同一时刻一个管道只会被一个调用方使用，管道总数不超过 size，
+// 被 broker 关闭的管道在取出或归还时丢弃，需要时重新打开。
+type channelPool struct {
+	open    func(ctx context.Context) (*amqp.Channel, error)
+	confirm bool
+	idle    chan *pooledChannel
+	sem     chan struct{} // 已打开的管道数
+}
+
+func newChannelPool(open func(ctx context.Context) (*amqp.Channel, error), size int, confirm bool) *channelPool {
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
+	return &channelPool{
+		open:    open,
+		confirm: confirm,
+		idle:    make(chan *pooledChannel, size),
+		sem:     make(chan struct{}, size),
+	}
+}
+
+func (p *channelPool) newChannel(ctx context.Context) (*pooledChannel, error) {
+	ch, err := p.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pc := &pooledChannel{ch: ch, closed: ch.NotifyClose(make(chan *amqp.Error, 1))}
+	if p.confirm {
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			return nil, fmt.Errorf("%s: %s", "Failed to put channel into confirm mode", err)
+		}
+		pc.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+		pc.returns = ch.NotifyReturn(make(chan amqp.Return, 1))
+	}
+	return pc, nil
+}
+
+// get 取出一个可用管道，池中没有空闲管道且已达上限时阻塞等待
+func (p *channelPool) get(ctx context.Context) (*pooledChannel, error) {
+	for {
+		select {
+		case pc := <-p.idle:
+			if pc.isClosed() {
+				<-p.sem
+				continue
+			}
+			return pc, nil
+		default:
+		}
+
+		select {
+		case pc := <-p.idle:
+			if pc.isClosed() {
+				<-p.sem
+				continue
+			}
+			return pc, nil
+		case p.sem <- struct{}{}:
+			pc, err := p.newChannel(ctx)
+			if err != nil {
+				<-p.sem
+				return nil, err
+			}
+			return pc, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// put 归还管道，err 为使用管道时产生的错误
+// 除了确认结果类错误外，出错的管道状态不确定（例如还有未读取的确认通知），直接关闭丢弃。
+func (p *channelPool) put(pc *pooledChannel, err error) {
+	if pc.isClosed() || !reusable(err) {
+		pc.ch.Close()
+		<-p.sem
+		return
+	}
+	p.idle <- pc
+}
+
+// reusable 使用管道时产生 err 后管道是否还能继续使用
+func reusable(err error) bool {
+	return err == nil || errors.Is(err, ErrNack) || errors.Is(err, ErrUnroutable)
+}
+
+// close 关闭所有空闲管道
+func (p *channelPool) close() {
+	for {
+		select {
+		case pc := <-p.idle:
+			pc.ch.Close()
+			<-p.sem
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// DefaultMaxRetries 默认最大重试次数
+const DefaultMaxRetries = 3
+
+// DefaultRetryDelays 默认的重试延迟，第 n 次重试使用第 n 个延迟
+var DefaultRetryDelays = []time.Duration{time.Second, 10 * time.Second, time.Minute}
+
+// ErrRetryLater handler 返回（或包装）该错误表示消息暂时无法处理（如相同的消息正在被其它消费者处理），
+// 消息经第一个重试延迟后重新投递，不计入重试次数，也不会因此进入死信队列。
+// 没有重试队列（广播订阅或不重试）时按普通错误处理。
+var ErrRetryLater = errors.New("rabbit: retry later")
+
+// retryQueueName 延迟重试队列名称，如 retry:order:10000
+func retryQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("retry:%s:%d", queue, delay.Milliseconds())
+}
+
+// declareRetryQueues 声明延迟重试队列
+// 重试队列没有消费者，消息过期后通过死信路由回原队列，每个延迟一个队列，避免队头消息阻塞。
+func declareRetryQueues(ch *amqp.Channel, queue string, o *consumeOptions) error {
+	if o.maxRetries <= 0 {
+		return nil
+	}
+	for _, delay := range o.retryDelays {
+		_, err := ch.QueueDeclare(
+			retryQueueName(queue, delay),
+			true,
+			false,
+			false,
+			false,
+			amqp.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": queue,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to declare a retry queue: %v", err)
+		}
+	}
+	return nil
+}
+
+// handle 执行 handler 并根据结果确认消息
+// 成功时 ack；失败时复制消息投递到延迟重试队列后 ack，超过重试次数则 reject 进入死信队列。
+// queue 为空（广播订阅）时失败的消息直接丢弃。
+// 消息头中的链路信息放入 handler 的 ctx，处理前后通知 Hook。
+func (c *Client) handle(ctx context.Context, o *consumeOptions, queue string, d amqp.Delivery, handler Handler) {
+	msg := &Message{Delivery: d, Queue: queue}
+	if tc, ok := extractTrace(d.Headers); ok {
+		ctx = ContextWithTrace(ctx, tc)
+	}
+	hook := c.getHook()
+	e := &ConsumeEvent{
+		Queue:       queue,
+		Exchange:    d.Exchange,
+		RoutingKey:  d.RoutingKey,
+		MessageID:   d.MessageId,
+		RetryCount:  msg.RetryCount(),
+		Redelivered: d.Redelivered,
+	}
+	ctx = hook.ConsumeStart(ctx, e)
+	start := time.Now()
+	e.Err = call(ctx, handler, msg)
+	e.Duration = time.Since(start)
+	e.Result = c.settle(o, queue, msg, e.Err)
+	hook.ConsumeDone(ctx, e)
+}
+
+// settle 根据 handler 的结果确认消息，返回处理结果
+func (c *Client) settle(o *consumeOptions, queue string, msg *Message, err error) ConsumeResult {
+	if msg.Settled() {
+		if err != nil {
+			log.Println("Handle message err,", queue, err.Error())
+		}
+		return ResultManual
+	}
+	if err == nil {
+		if err := msg.Ack(); err != nil {
+			log.Println("ack message err", err.Error())
+		}
+		return ResultAck
+	}
+
+	count := msg.RetryCount()
+	if errors.Is(err, ErrRetryLater) && queue != "" && o.maxRetries > 0 && len(o.retryDelays) > 0 {
+		return c.retry(queue, msg, o.retryDelays[0], count)
+	}
+	log.Printf("Handle message err,queue:%s retry:%d,%s\n", queue, count, err.Error())
+	if queue == "" || count >= o.maxRetries || len(o.retryDelays) == 0 {
+		// 队列声明了死信参数，reject 后由 broker 投递到 dead:<queue>，并记录 x-death
+		if err := msg.Reject(false); err != nil {
+			log.Println("reject message err", err.Error())
+		}
+		return ResultDeadLetter
+	}
+
+	delay := o.retryDelays[len(o.retryDelays)-1]
+	if count < len(o.retryDelays) {
+		delay = o.retryDelays[count]
+	}
+	return c.retry(queue, msg, delay, count+1)
+}
+
+// retry 将消息投递到 delay 对应的延迟重试队列，重试次数记为 count，成功后确认原消息
+func (c *Client) retry(queue string, msg *Message, delay time.Duration, count int) ConsumeResult {
+	if err := c.republish(retryQueueName(queue, delay), msg.Delivery, count); err != nil {
+		// 投递重试队列失败时重新入队，避免消息丢失
+		log.Println("publish retry message err", err.Error())
+		if err := msg.Nack(true); err != nil {
+			log.Println("nack message err", err.Error())
+		}
+		return ResultRequeue
+	}
+	if err := msg.Ack(); err != nil {
+		log.Println("ack message err", err.Error())
+	}
+	return ResultRetry
+}
+
+// republish 复制消息并以确认模式投递到指定队列，重试次数写入 x-retry-count
+func (c *Client) republish(queue string, d amqp.Delivery, retry int) error {
+	msg := copyPublishing(d)
+	msg.Headers[HeaderRetryCount] = int32(retry)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.confirmTimeout())
+	defer cancel()
+	return c.confirmPublish(ctx, "", queue, msg)
+}
+
+// copyPublishing 复制收到的消息用于重新发布
+func copyPublishing(d amqp.Delivery) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
@@ -0,0 +1,270 @@
+package rabbit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	// DirectReplyTo RabbitMQ 内置的伪队列，用于直接回复，无需声明回调队列
+	DirectReplyTo = "amq.rabbitmq.reply-to"
+	// HeaderRPCError 服务端处理失败时，在响应中携带错误信息的消息头
+	HeaderRPCError = "x-rpc-error"
+)
+
+// RPCError 服务端返回的错误
+type RPCError struct {
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return "rabbit: rpc server error: " + e.Message
+}
+
+type rpcReply struct {
+	msg amqp.Delivery
+	err error
+}
+
+// result 响应对应的返回值，服务端通过 x-rpc-error 返回的错误转换为 *RPCError
+func (reply rpcReply) result() ([]byte, error) {
+	if reply.err != nil {
+		return nil, reply.err
+	}
+	if text, ok := reply.msg.Headers[HeaderRPCError].(string); ok {
+		return reply.msg.Body, &RPCError{Message: text}
+	}
+	return reply.msg.Body, nil
+}
+
+// RPCClient 基于 RabbitMQ 的 RPC 客户端，并发安全
+// 请求以 ReplyTo 与 CorrelationId 发布到服务端队列，按 CorrelationId 匹配响应。
+type RPCClient struct {
+	c      *Client
+	direct bool
+
+	mu      sync.Mutex
+	ch      *amqp.Channel
+	replyTo string
+	pending map[string]chan rpcReply
+}
+
+// NewRPCClient 创建 RPC 客户端
+// direct 为 true 时使用 direct reply-to（amq.rabbitmq.reply-to），否则声明一个排他的回调队列。
+// 管道或连接断开时等待中的请求返回错误，下一次调用时重新建立回调。
+func (c *Client) NewRPCClient(direct bool) *RPCClient {
+	return &RPCClient{c: c, direct: direct, pending: make(map[string]chan rpcReply)}
+}
+
+// setup 打开管道并开始消费回调队列，需持有 r.mu
+func (r *RPCClient) setup(ctx context.Context) error {
+	if r.ch != nil {
+		return nil
+	}
+	ch, err := r.c.channel(ctx)
+	if err != nil {
+		return err
+	}
+	replyTo := DirectReplyTo
+	if !r.direct {
+		q, err := ch.QueueDeclare("", false, true, true, false, nil)
+		if err != nil {
+			ch.Close()
+			return fmt.Errorf("%s: %s", "Failed to declare a callback queue", err)
+		}
+		replyTo = q.Name
+	}
+	// direct reply-to 要求以 auto-ack 方式消费，并且在同一个管道上发布请求
+	replies, err := ch.Consume(replyTo, "", true, true, false, false, nil)
+	if err != nil {
+		ch.Close()
+		return fmt.Errorf("%s: %s", "Failed to consume a callback queue", err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 1))
+
+	r.ch, r.replyTo = ch, replyTo
+	go r.receive(ch, replies, returns)
+	return nil
+}
+
+// receive 分发响应与退回的请求，管道关闭后让所有等待中的请求失败
+func (r *RPCClient) receive(ch *amqp.Channel, replies <-chan amqp.Delivery, returns <-chan amqp.Return) {
+	for replies != nil || returns != nil {
+		select {
+		case msg, ok := <-replies:
+			if !ok {
+				replies = nil
+				continue
+			}
+			r.reply(msg.CorrelationId, rpcReply{msg: msg})
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			r.reply(ret.CorrelationId, rpcReply{err: &ReturnError{
+				Exchange:   ret.Exchange,
+				RoutingKey: ret.RoutingKey,
+				ReplyCode:  ret.ReplyCode,
+				ReplyText:  ret.ReplyText,
+			}})
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ch == ch {
+		r.ch = nil
+	}
+	for id, wait := range r.pending {
+		wait <- rpcReply{err: fmt.Errorf("%s: %s", "Failed to wait rpc reply", amqp.ErrClosed)}
+		delete(r.pending, id)
+	}
+}
+
+func (r *RPCClient) reply(id string, reply rpcReply) {
+	r.mu.Lock()
+	wait, ok := r.pending[id]
+	delete(r.pending, id)
+	r.mu.Unlock()
+	if ok {
+		wait <- reply
+	} else {
+		log.Println("Drop unknown rpc reply,", id)
+	}
+}
+
+// Call 发送请求到 queue 并等待响应，ctx 结束时返回错误
+// ctx 设置了截止时间时，请求消息的过期时间与之一致，服务端不会处理已超时的请求。
+// 服务端处理失败时返回 *RPCError，queue 不存在时返回 *ReturnError。
+func (r *RPCClient) Call(ctx context.Context, queue string, body []byte, opts ...PublishOption) ([]byte, error) {
+	msg := newPublishing(body, 0)
+	for _, opt := range opts {
+		opt(&msg)
+	}
+	msg.CorrelationId = NewMessageID()
+	if deadline, ok := ctx.Deadline(); ok {
+		ms := time.Until(deadline).Milliseconds()
+		if ms <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		msg.Expiration = strconv.FormatInt(ms, 10)
+	}
+	wait := make(chan rpcReply, 1)
+
+	r.mu.Lock()
+	if err := r.setup(ctx); err != nil {
+		r.mu.Unlock()
+		return nil, err
+	}
+	msg.ReplyTo = r.replyTo
+	r.pending[msg.CorrelationId] = wait
+	err := r.c.observePublish(ctx, "", queue, false, msg, func(msg amqp.Publishing, _ *PublishEvent) error {
+		if err := r.ch.Publish("", queue, true, false, msg); err != nil {
+			return fmt.Errorf("%s: %s", "Failed to publish a rpc request", err)
+		}
+		return nil
+	})
+	if err != nil {
+		delete(r.pending, msg.CorrelationId)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-wait:
+		return reply.result()
+	case <-ctx.Done():
+		r.mu.Lock()
+		delete(r.pending, msg.CorrelationId)
+		r.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// Close 关闭回调管道
+func (r *RPCClient) Close() error {
+	r.mu.Lock()
+	ch := r.ch
+	r.ch = nil
+	r.mu.Unlock()
+	if ch == nil {
+		return nil
+	}
+	if err := ch.Close(); err != nil && !errors.Is(err, amqp.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// RPCHandler RPC 服务端处理函数，返回的数据作为响应体，返回错误时错误信息通过 x-rpc-error 消息头返回
+type RPCHandler func(ctx context.Context, msg *Message) ([]byte, error)
+
+// ServeRPC 消费请求队列 queue，将 handler 的结果发布到请求的 ReplyTo
+// 调用方在等待响应，失败的请求不会重试，错误（包括 handler panic）返回给调用方后确认消息。其它行为与 Consume 一致。
+// 请求中的链路信息会写入响应的消息头。
+func (c *Client) ServeRPC(ctx context.Context, queue string, handler RPCHandler, opts ...ConsumeOption) error {
+	opts = append(opts, WithRetry(0))
+	return c.Consume(ctx, queue, 0, c.rpcHandler(handler), opts...)
+}
+
+// rpcHandler 将 RPCHandler 包装为 Handler，执行后发布响应
+func (c *Client) rpcHandler(handler RPCHandler) Handler {
+	return func(ctx context.Context, msg *Message) error {
+		body, err := callRPC(ctx, handler, msg)
+		if msg.ReplyTo == "" {
+			return err
+		}
+		var headers amqp.Table
+		if err != nil {
+			headers = amqp.Table{HeaderRPCError: err.Error()}
+		}
+		// 消费端停止时 ctx 会被取消，响应仍需发出
+		err = c.PublishExchangeCtx(detached{ctx}, "", msg.ReplyTo, body, headers, WithCorrelationID(msg.CorrelationId))
+		if err != nil {
+			// 响应发布失败时消息进入死信队列，调用方等待超时
+			return err
+		}
+		return nil
+	}
+}
+
+// callRPC 执行 handler，panic 时记录堆栈并转换为错误，错误信息返回给调用方，不包含堆栈
+func callRPC(ctx context.Context, handler RPCHandler, msg *Message) (body []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 64<<10)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Printf("rabbit: rpc handler panic recovered: %v\n%s", r, buf)
+			body, err = nil, fmt.Errorf("rabbit: rpc handler panic recovered: %v", r)
+		}
+	}()
+	return handler(ctx, msg)
+}
+
+// detached 保留 ctx 中的值（如链路信息），但不随 ctx 取消
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
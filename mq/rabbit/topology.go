@@ -0,0 +1,370 @@
+package rabbit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/streadway/amqp"
+	"gopkg.in/yaml.v3"
+)
+
+// Topology 声明式拓扑，在 NewRabbit 时按 交换机、队列、绑定 的顺序幂等声明
+type Topology struct {
+	Exchanges []Exchange    `mapstructure:"exchanges" json:"exchanges" yaml:"exchanges"`
+	Queues    []QueueConfig `mapstructure:"queues" json:"queues" yaml:"queues"`
+	Bindings  []Binding     `mapstructure:"bindings" json:"bindings" yaml:"bindings"`
+}
+
+// 队列类型
+const (
+	QueueClassic = "classic" // 经典队列
+	QueueQuorum  = "quorum"  // 仲裁队列，基于 Raft 复制，必须持久化且不能排他
+	QueueStream  = "stream"  // 流队列，消息只追加不删除，消费时可指定起始位置，见 WithStreamOffset
+)
+
+// 队列达到最大长度时的处理方式
+const (
+	OverflowDropHead         = "drop-head"          // 丢弃（或死信）队头最旧的消息，默认
+	OverflowRejectPublish    = "reject-publish"     // 拒绝新消息，确认模式下发布端收到 nack
+	OverflowRejectPublishDLX = "reject-publish-dlx" // 拒绝新消息并投递到死信，仅经典队列支持
+)
+
+// QueueConfig 队列声明参数
+// 配置过的队列在 Publish/Consume 等方法中都按该配置声明，保证生产端与消费端参数一致。
+type QueueConfig struct {
+	Name       string     `mapstructure:"name" json:"name" yaml:"name"`
+	Durable    bool       `mapstructure:"durable" json:"durable" yaml:"durable"`
+	AutoDelete bool       `mapstructure:"auto_delete" json:"auto_delete" yaml:"auto_delete"`
+	Exclusive  bool       `mapstructure:"exclusive" json:"exclusive" yaml:"exclusive"`
+	Expire     int64      `mapstructure:"expire" json:"expire" yaml:"expire"`                // 消息过期时间（秒），0 表示永久有效
+	DeadLetter bool       `mapstructure:"dead_letter" json:"dead_letter" yaml:"dead_letter"` // 是否声明死信队列 dead:<name>，与 Publish 的默认行为一致
+	DLX        string     `mapstructure:"dlx" json:"dlx" yaml:"dlx"`                         // 自定义死信交换机，设置后 DeadLetter 不生效
+	DLXKey     string     `mapstructure:"dlx_key" json:"dlx_key" yaml:"dlx_key"`             // 自定义死信 routing key
+	MaxLength  int64      `mapstructure:"max_length" json:"max_length" yaml:"max_length"`    // 队列最大消息数，0 表示不限制
+	Type       string     `mapstructure:"type" json:"type" yaml:"type"`                      // 队列类型：classic、quorum、stream，默认 classic
+	Args       amqp.Table `mapstructure:"args" json:"args" yaml:"args"`                      // 其它参数
+
+	MaxLengthBytes       int64  `mapstructure:"max_length_bytes" json:"max_length_bytes" yaml:"max_length_bytes"`                   // 队列最大字节数，0 表示不限制
+	Overflow             string `mapstructure:"overflow" json:"overflow" yaml:"overflow"`                                           // 达到最大长度时的处理方式，默认 drop-head
+	MaxPriority          uint8  `mapstructure:"max_priority" json:"max_priority" yaml:"max_priority"`                               // 最大优先级（1-255，建议不超过10），0 表示不支持优先级，仅经典队列
+	Lazy                 bool   `mapstructure:"lazy" json:"lazy" yaml:"lazy"`                                                       // 惰性模式，消息尽早写入磁盘，适合长时间积压的队列，仅经典队列
+	SingleActiveConsumer bool   `mapstructure:"single_active_consumer" json:"single_active_consumer" yaml:"single_active_consumer"` // 同一时刻只有一个消费者接收消息，其余消费者作为备用
+	MaxAge               string `mapstructure:"max_age" json:"max_age" yaml:"max_age"`                                              // 流队列消息保留时间，如 7D、12h，仅流队列
+}
+
+// Binding 绑定声明参数，Headers 不为空时以消息头匹配的方式绑定
+type Binding struct {
+	Queue      string     `mapstructure:"queue" json:"queue" yaml:"queue"`
+	Exchange   string     `mapstructure:"exchange" json:"exchange" yaml:"exchange"`
+	RoutingKey string     `mapstructure:"routing_key" json:"routing_key" yaml:"routing_key"`
+	Headers    amqp.Table `mapstructure:"headers" json:"headers" yaml:"headers"`
+	MatchAll   bool       `mapstructure:"match_all" json:"match_all" yaml:"match_all"`
+}
+
+// args 队列声明参数
+func (q QueueConfig) args() amqp.Table {
+	args := amqp.Table{}
+	for k, v := range normalizeTable(q.Args) {
+		args[k] = v
+	}
+	switch {
+	case q.DLX != "":
+		args["x-dead-letter-exchange"] = q.DLX
+		if q.DLXKey != "" {
+			args["x-dead-letter-routing-key"] = q.DLXKey
+		}
+	case q.DeadLetter:
+		args["x-dead-letter-exchange"] = ""
+		args["x-dead-letter-routing-key"] = DeadQueueName(q.Name)
+	}
+	if q.Expire > 0 {
+		args["x-message-ttl"] = q.Expire * 1000
+	}
+	if q.MaxLength > 0 {
+		args["x-max-length"] = q.MaxLength
+	}
+	if q.Type != "" {
+		args["x-queue-type"] = q.Type
+	}
+	if q.MaxLengthBytes > 0 {
+		args["x-max-length-bytes"] = q.MaxLengthBytes
+	}
+	if q.Overflow != "" {
+		args["x-overflow"] = q.Overflow
+	}
+	if q.MaxPriority > 0 {
+		args["x-max-priority"] = int32(q.MaxPriority)
+	}
+	if q.Lazy {
+		args["x-queue-mode"] = "lazy"
+	}
+	if q.SingleActiveConsumer {
+		args["x-single-active-consumer"] = true
+	}
+	if q.MaxAge != "" {
+		args["x-max-age"] = q.MaxAge
+	}
+	return args
+}
+
+// validate 检查队列类型与参数的组合，避免声明时才由 broker 关闭管道
+func (q QueueConfig) validate() error {
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("queue %q: %s", q.Name, fmt.Sprintf(format, args...))
+	}
+	switch q.Overflow {
+	case "", OverflowDropHead, OverflowRejectPublish, OverflowRejectPublishDLX:
+	default:
+		return invalid("unknown overflow %q", q.Overflow)
+	}
+	switch q.Type {
+	case "", QueueClassic:
+		if q.MaxAge != "" {
+			return invalid("max_age requires a stream queue")
+		}
+		return nil
+	case QueueQuorum, QueueStream:
+	default:
+		return invalid("unknown queue type %q", q.Type)
+	}
+
+	if !q.Durable || q.Exclusive || q.AutoDelete {
+		return invalid("%s queue must be durable, non-exclusive and non-auto-delete", q.Type)
+	}
+	if q.Lazy {
+		return invalid("lazy mode requires a classic queue")
+	}
+	if q.MaxPriority > 0 {
+		return invalid("max_priority requires a classic queue")
+	}
+	if q.Overflow == OverflowRejectPublishDLX {
+		return invalid("overflow %s requires a classic queue", q.Overflow)
+	}
+	if q.Type == QueueQuorum && q.MaxAge != "" {
+		return invalid("max_age requires a stream queue")
+	}
+	if q.Type == QueueStream {
+		if q.Expire > 0 || q.DeadLetter || q.DLX != "" || q.SingleActiveConsumer {
+			return invalid("stream queue does not support expire, dead letter or single active consumer")
+		}
+	}
+	return nil
+}
+
+// bindArgs 绑定参数
+func (b Binding) bindArgs() amqp.Table {
+	if len(b.Headers) == 0 {
+		return nil
+	}
+	args := normalizeTable(b.Headers)
+	if b.MatchAll {
+		args["x-match"] = "all"
+	} else {
+		args["x-match"] = "any"
+	}
+	return args
+}
+
+// normalizeTable 转换配置文件解析出的参数类型，
+// JSON 中的数字会被解析为 float64，而 broker 要求 x-message-ttl 等参数为整数
+func normalizeTable(table amqp.Table) amqp.Table {
+	if table == nil {
+		return nil
+	}
+	out := make(amqp.Table, len(table))
+	for k, v := range table {
+		switch n := v.(type) {
+		case float64:
+			if n == math.Trunc(n) {
+				v = int64(n)
+			}
+		case int:
+			v = int64(n)
+		case map[string]interface{}:
+			v = normalizeTable(n)
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// declareQueueConfig 按配置声明队列
+func declareQueueConfig(ch *amqp.Channel, cfg QueueConfig) (*amqp.Queue, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.DeadLetter && cfg.DLX == "" {
+		if _, err := ch.QueueDeclare(DeadQueueName(cfg.Name), true, false, false, false, nil); err != nil {
+			return nil, conflictError("queue", DeadQueueName(cfg.Name), err)
+		}
+	}
+	q, err := ch.QueueDeclare(cfg.Name, cfg.Durable, cfg.AutoDelete, cfg.Exclusive, false, cfg.args())
+	if err != nil {
+		return nil, conflictError("queue", cfg.Name, err)
+	}
+	return &q, nil
+}
+
+// queueConfig 获取已配置的队列
+func (c *Client) queueConfig(queue string) (QueueConfig, bool) {
+	c.topoMu.Lock()
+	defer c.topoMu.Unlock()
+	cfg, ok := c.queues[queue]
+	return cfg, ok
+}
+
+// queueDeclare 声明队列，已配置的队列按配置声明，否则按 Publish/Consume 的默认参数声明
+func (c *Client) queueDeclare(ch *amqp.Channel, queue string, expire int64) (*amqp.Queue, error) {
+	if cfg, ok := c.queueConfig(queue); ok {
+		return declareQueueConfig(ch, cfg)
+	}
+	return declareQueue(ch, queue, true, expire)
+}
+
+// DeclareQueue 按配置声明队列并记录，之后该队列的发布与消费都使用此配置，断线重连后自动重新声明
+func (c *Client) DeclareQueue(cfg QueueConfig) error {
+	err := c.withChannel(func(ch *amqp.Channel) error {
+		_, err := declareQueueConfig(ch, cfg)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	c.topoMu.Lock()
+	c.queues[cfg.Name] = cfg
+	c.topoMu.Unlock()
+	return nil
+}
+
+// ApplyTopology 按 交换机、队列、绑定 的顺序声明拓扑
+// 声明是幂等的；与 broker 上已存在的定义不一致时继续声明其它项，最后返回 *TopologyError，列出所有差异。
+func (c *Client) ApplyTopology(topology *Topology) error {
+	if topology == nil {
+		return nil
+	}
+	var errs TopologyError
+	for _, exchange := range topology.Exchanges {
+		exchange.Args = normalizeTable(exchange.Args)
+		if err := c.DeclareExchange(exchange); err != nil {
+			errs = append(errs, conflictError("exchange", exchange.Name, err))
+		}
+	}
+	for _, queue := range topology.Queues {
+		if err := c.DeclareQueue(queue); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, b := range topology.Bindings {
+		if err := c.bindQueue(b.Queue, b.Exchange, b.RoutingKey, b.bindArgs()); err != nil {
+			errs = append(errs, fmt.Errorf("binding %s -> %s(%s): %w", b.Exchange, b.Queue, b.RoutingKey, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// TopologyError 声明拓扑时的所有错误
+type TopologyError []error
+
+func (e TopologyError) Error() string {
+	lines := make([]string, 0, len(e)+1)
+	lines = append(lines, fmt.Sprintf("rabbit: apply topology failed with %d errors:", len(e)))
+	for _, err := range e {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Is 任一错误匹配 target 时返回 true，Go 1.20 之前 errors.Is 不会展开 Unwrap() []error
+func (e TopologyError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As 将第一个匹配 target 的错误赋给 target，见 Is
+func (e TopologyError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e TopologyError) Unwrap() []error {
+	return e
+}
+
+// ConflictError 声明的定义与 broker 上已存在的定义不一致
+type ConflictError struct {
+	Kind     string // queue 或 exchange
+	Name     string
+	Arg      string // 不一致的参数，如 durable、x-message-ttl
+	Declared string // 本次声明的值
+	Existing string // broker 上已存在的值
+	Err      error
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s %q conflicts with broker: %s declared %s, existing %s", e.Kind, e.Name, e.Arg, e.Declared, e.Existing)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// 如 PRECONDITION_FAILED - inequivalent arg 'x-message-ttl' for queue 'order' in vhost '/': received the value '60000' of type 'long' but current is none
+var inequivalentArg = regexp.MustCompile(`inequivalent arg '([^']+)' for (queue|exchange) '([^']*)' in vhost '[^']*': received (.+) but current is ([^"]+)`)
+
+// conflictError 将声明冲突的错误转换为 *ConflictError，其它错误加上名称后返回
+func conflictError(kind, name string, err error) error {
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		return err
+	}
+	m := inequivalentArg.FindStringSubmatch(err.Error())
+	if m == nil {
+		return fmt.Errorf("%s %q: %w", kind, name, err)
+	}
+	return &ConflictError{
+		Kind:     m[2],
+		Name:     m[3],
+		Arg:      m[1],
+		Declared: strings.TrimPrefix(m[4], "the value "),
+		Existing: strings.TrimPrefix(m[5], "the value "),
+		Err:      err,
+	}
+}
+
+// LoadOption 从 YAML 或 JSON 文件读取配置，按扩展名区分格式
+func LoadOption(path string) (*Option, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	option := &Option{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, option)
+	case ".json":
+		err = json.Unmarshal(data, option)
+	default:
+		return nil, fmt.Errorf("rabbit: unsupported option file %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", "Failed to parse option file", err)
+	}
+	return option, nil
+}
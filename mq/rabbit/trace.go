@@ -0,0 +1,127 @@
+package rabbit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// W3C Trace Context 消息头，https://www.w3.org/TR/trace-context/
+const (
+	HeaderTraceParent = "traceparent"
+	HeaderTraceState  = "tracestate"
+)
+
+// TraceContext W3C Trace Context 链路信息
+// 发布时从 ctx 中取出写入消息头，消费时从消息头解析后放入 handler 的 ctx，
+// 接入 OpenTelemetry 等链路追踪时在 Hook 中与其 SpanContext 相互转换。
+type TraceContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte   // 01 表示采样
+	State   string // tracestate，原样传递
+}
+
+type traceContextKey struct{}
+
+// ContextWithTrace 将链路信息放入 ctx
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceFromContext 取出 ctx 中的链路信息
+func TraceFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok && tc.IsValid()
+}
+
+// NewTraceContext 生成新的链路，parent 有效时沿用其 TraceID 与采样标记，只生成新的 SpanID
+func NewTraceContext(parent TraceContext) TraceContext {
+	tc := parent
+	if !parent.IsValid() {
+		_, _ = rand.Read(tc.TraceID[:])
+		tc.Flags = 1
+		tc.State = ""
+	}
+	_, _ = rand.Read(tc.SpanID[:])
+	return tc
+}
+
+// IsValid TraceID 与 SpanID 均不为全零
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// Sampled 是否采样
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&1 == 1
+}
+
+// String traceparent 格式，如 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (tc TraceContext) String() string {
+	return fmt.Sprintf("00-%x-%x-%02x", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+// ParseTraceParent 解析 traceparent 消息头
+func ParseTraceParent(s string) (TraceContext, error) {
+	var tc TraceContext
+	// version-traceid-spanid-flags，未来版本可能在末尾追加字段
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' || (len(s) > 55 && s[55] != '-') {
+		return tc, fmt.Errorf("rabbit: invalid traceparent %q", s)
+	}
+	version, err := hex.DecodeString(s[:2])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(s) != 55) {
+		return tc, fmt.Errorf("rabbit: invalid traceparent %q", s)
+	}
+	flags, err := hex.DecodeString(s[53:55])
+	if err != nil {
+		return tc, fmt.Errorf("rabbit: invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(tc.TraceID[:], []byte(s[3:35])); err != nil {
+		return tc, fmt.Errorf("rabbit: invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(tc.SpanID[:], []byte(s[36:52])); err != nil {
+		return tc, fmt.Errorf("rabbit: invalid traceparent %q", s)
+	}
+	tc.Flags = flags[0]
+	if !tc.IsValid() {
+		return TraceContext{}, fmt.Errorf("rabbit: invalid traceparent %q", s)
+	}
+	return tc, nil
+}
+
+// injectTrace 将 ctx 中的链路信息写入消息头，ctx 中没有链路信息时保留消息原有的链路头（如重试时复制的消息）
+func injectTrace(ctx context.Context, msg *amqp.Publishing) {
+	tc, ok := TraceFromContext(ctx)
+	if !ok {
+		return
+	}
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[HeaderTraceParent] = tc.String()
+	if tc.State != "" {
+		headers[HeaderTraceState] = tc.State
+	} else {
+		delete(headers, HeaderTraceState)
+	}
+	msg.Headers = headers
+}
+
+// extractTrace 从消息头解析链路信息
+func extractTrace(headers amqp.Table) (TraceContext, bool) {
+	s, ok := headers[HeaderTraceParent].(string)
+	if !ok {
+		return TraceContext{}, false
+	}
+	tc, err := ParseTraceParent(s)
+	if err != nil {
+		return TraceContext{}, false
+	}
+	tc.State, _ = headers[HeaderTraceState].(string)
+	return tc, true
+}
@@ -0,0 +1,106 @@
+package xgin
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yiuked/gopkg/xlog"
+)
+
+// LoggerConfig 访问日志配置
+type LoggerConfig struct {
+	// MaxBodySize 记录请求体与响应体的最大字节数，超出部分截断，0 表示不记录
+	MaxBodySize int
+	// ResponseBody 是否记录响应体
+	ResponseBody bool
+	// SkipPaths 不记录日志的路径，如健康检查
+	SkipPaths []string
+}
+
+type bodyWriter struct {
+	gin.ResponseWriter
+	body  *bytes.Buffer
+	limit int
+}
+
+func (w *bodyWriter) Write(b []byte) (int, error) {
+	if n := w.limit - w.body.Len(); n > 0 {
+		if len(b) < n {
+			n = len(b)
+		}
+		w.body.Write(b[:n])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyWriter) WriteString(s string) (int, error) {
+	if n := w.limit - w.body.Len(); n > 0 {
+		if len(s) < n {
+			n = len(s)
+		}
+		w.body.WriteString(s[:n])
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Logger 访问日志中间件，记录请求方法、路径、状态码、耗时，及截断后的请求体/响应体
+func Logger(config LoggerConfig) gin.HandlerFunc {
+	skip := make(map[string]struct{}, len(config.SkipPaths))
+	for _, path := range config.SkipPaths {
+		skip[path] = struct{}{}
+	}
+
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if _, ok := skip[path]; ok {
+			c.Next()
+			return
+		}
+		start := time.Now()
+
+		var reqBody []byte
+		if config.MaxBodySize > 0 && c.Request.Body != nil {
+			// 只读取上限内的数据，剩余部分原样拼接回去，不影响后续处理
+			reqBody, _ = io.ReadAll(io.LimitReader(c.Request.Body, int64(config.MaxBodySize)))
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(reqBody), c.Request.Body), c.Request.Body}
+		}
+
+		var w *bodyWriter
+		if config.ResponseBody && config.MaxBodySize > 0 {
+			w = &bodyWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}, limit: config.MaxBodySize}
+			c.Writer = w
+		}
+
+		c.Next()
+
+		if c.Request.URL.RawQuery != "" {
+			path = path + "?" + c.Request.URL.RawQuery
+		}
+		status := c.Writer.Status()
+		format := "[%s] %s %s %d %s %s %dB req:%s"
+		args := []interface{}{RequestID(c), c.Request.Method, path, status, time.Since(start), c.ClientIP(), c.Writer.Size(), reqBody}
+		if w != nil {
+			format += " resp:%s"
+			args = append(args, w.body.Bytes())
+		}
+		if len(c.Errors) > 0 {
+			format += " errors:%s"
+			args = append(args, c.Errors.String())
+		}
+		format += "\n"
+
+		switch {
+		case status >= 500:
+			xlog.Errorf(format, args...)
+		case status >= 400:
+			xlog.Warnf(format, args...)
+		default:
+			xlog.Infof(format, args...)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package xgin
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yiuked/gopkg/xlog"
+)
+
+// Recovery panic 恢复中间件，通过 xlog 记录错误与堆栈，并返回统一的错误响应
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				stack := debug.Stack()
+				// 客户端断开连接时无法再写入响应
+				if brokenPipe(r) {
+					xlog.Errorf("[%s] %s %s panic recovered: %v\n", RequestID(c), c.Request.Method, c.Request.URL.Path, r)
+					_ = c.Error(r.(error))
+					c.Abort()
+					return
+				}
+				xlog.Errorf("[%s] %s %s panic recovered: %v\n%s", RequestID(c), c.Request.Method, c.Request.URL.Path, r, stack)
+				JSON(c, http.StatusInternalServerError, CodeInternal, InternalMsg, nil)
+				c.Abort()
+			}
+		}()
+		c.Next()
+	}
+}
+
+func brokenPipe(r interface{}) bool {
+	err, ok := r.(error)
+	if !ok {
+		return false
+	}
+	var ne *net.OpError
+	if !errors.As(err, &ne) {
+		return false
+	}
+	var se *os.SyscallError
+	if errors.As(ne, &se) {
+		msg := strings.ToLower(se.Error())
+		return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package xgin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderRequestID 请求ID的请求头/响应头
+const HeaderRequestID = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// RequestIDGenerator 请求ID生成函数，可替换
+var RequestIDGenerator = func() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestIDMiddleware 请求ID中间件
+// 优先使用上游传入的请求ID，没有则生成一个新的，并写入响应头与 request 的 context 中，
+// 业务代码调用下游服务时，可通过 RequestIDFromContext 取出继续传递。
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(HeaderRequestID)
+		if id == "" || len(id) > 128 {
+			id = RequestIDGenerator()
+		}
+		c.Set(HeaderRequestID, id)
+		c.Header(HeaderRequestID, id)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// RequestID 获取当前请求的请求ID
+func RequestID(c *gin.Context) string {
+	return c.GetString(HeaderRequestID)
+}
+
+// WithRequestID 将请求ID写入 context
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext 从 context 中获取请求ID
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
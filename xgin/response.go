@@ -0,0 +1,137 @@
+package xgin
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yiuked/gopkg/xerr"
+	"github.com/yiuked/gopkg/xlog"
+)
+
+const (
+	CodeSuccess  = 0
+	CodeFail     = 1
+	CodeParams   = 400
+	CodeNotFound = 404
+	CodeInternal = 500
+	CodeDB       = 501
+)
+
+var (
+	SuccessMsg  = "success"
+	InternalMsg = "服务器内部错误"
+)
+
+// Response 统一响应结构
+type Response struct {
+	Code      int         `json:"code"`
+	Msg       string      `json:"msg"`
+	Data      interface{} `json:"data"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// codes 错误与业务码的对应关系，Error 按 errors.Is 匹配，读写需持有 codesMu
+var codesMu sync.RWMutex
+var codes = []struct {
+	err  error
+	code int
+}{
+	{xerr.ParamsErr, CodeParams},
+	{xerr.DBErr, CodeDB},
+}
+
+// RegisterCode 注册错误对应的业务码，后注册的优先匹配
+func RegisterCode(err error, code int) {
+	codesMu.Lock()
+	defer codesMu.Unlock()
+	codes = append([]struct {
+		err  error
+		code int
+	}{{err, code}}, codes...)
+}
+
+// CodeOf 获取错误对应的业务码，未注册的错误返回 CodeFail
+func CodeOf(err error) int {
+	if code, ok := lookup(err); ok {
+		return code
+	}
+	return CodeFail
+}
+
+// lookup 查找错误注册的业务码
+func lookup(err error) (int, bool) {
+	codesMu.RLock()
+	defer codesMu.RUnlock()
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code, true
+		}
+	}
+	return 0, false
+}
+
+// JSON 输出统一响应结构
+func JSON(c *gin.Context, httpStatus, code int, msg string, data interface{}) {
+	c.JSON(httpStatus, Response{
+		Code:      code,
+		Msg:       msg,
+		Data:      data,
+		RequestID: RequestID(c),
+	})
+}
+
+// Success 成功响应
+func Success(c *gin.Context, data interface{}) {
+	JSON(c, http.StatusOK, CodeSuccess, SuccessMsg, data)
+}
+
+// Fail 失败响应，使用自定义业务码与提示信息
+func Fail(c *gin.Context, code int, msg string) {
+	JSON(c, http.StatusOK, code, msg, nil)
+}
+
+// Error 错误响应
+// err 为 *xerr.XError 时，OutErr 作为提示信息返回给调用方，InErr 仅记录日志；
+// 其它已通过 RegisterCode 注册的错误返回错误信息，未注册的错误只记录日志，返回 InternalMsg，
+// 避免 SQL、驱动等内部错误信息泄露给调用方。
+func Error(c *gin.Context, err error) {
+	if err == nil {
+		Success(c, nil)
+		return
+	}
+	code, msg := resolve(c, err)
+	JSON(c, http.StatusOK, code, msg, nil)
+}
+
+// Abort 以指定的 http 状态码输出错误响应，并终止后续处理
+func Abort(c *gin.Context, httpStatus int, err error) {
+	code, msg := CodeInternal, InternalMsg
+	if err != nil {
+		code, msg = resolve(c, err)
+	}
+	JSON(c, httpStatus, code, msg, nil)
+	c.Abort()
+}
+
+// resolve 解析错误对应的业务码与提示信息
+func resolve(c *gin.Context, err error) (int, string) {
+	var xe *xerr.XError
+	if errors.As(err, &xe) {
+		if xe.InErr != nil {
+			xlog.Errorf("[%s] %s %s in err:%s\n", RequestID(c), c.Request.Method, c.Request.URL.Path, xe.InErr)
+		}
+		if xe.OutErr == nil {
+			return CodeInternal, InternalMsg
+		}
+		_ = c.Error(xe.OutErr)
+		return CodeOf(xe.OutErr), xe.OutErr.Error()
+	}
+	_ = c.Error(err)
+	if code, ok := lookup(err); ok {
+		return code, err.Error()
+	}
+	xlog.Errorf("[%s] %s %s err:%s\n", RequestID(c), c.Request.Method, c.Request.URL.Path, err)
+	return CodeInternal, InternalMsg
+}
@@ -0,0 +1,84 @@
+package xgin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yiuked/gopkg/xerr"
+)
+
+func newEngine() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RequestIDMiddleware(), Logger(LoggerConfig{MaxBodySize: 8, ResponseBody: true}), Recovery())
+	r.GET("/ok", func(c *gin.Context) {
+		Success(c, gin.H{"id": 1})
+	})
+	r.POST("/params", func(c *gin.Context) {
+		Error(c, xerr.NewXErr(errors.New("bind failed"), xerr.ParamsErr))
+	})
+	r.GET("/db", func(c *gin.Context) {
+		Error(c, errors.New("Error 1146: Table 'shop.user' doesn't exist"))
+	})
+	r.GET("/db-registered", func(c *gin.Context) {
+		Error(c, xerr.DBErr)
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	return r
+}
+
+func do(t *testing.T, r *gin.Engine, req *http.Request) (*httptest.ResponseRecorder, Response) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	var resp Response
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	return w, resp
+}
+
+func TestSuccess(t *testing.T) {
+	r := newEngine()
+	req := httptest.NewRequest(http.MethodGet, "/ok", nil)
+	req.Header.Set(HeaderRequestID, "abc")
+	w, resp := do(t, r, req)
+	if resp.Code != CodeSuccess || resp.RequestID != "abc" || w.Header().Get(HeaderRequestID) != "abc" {
+		t.Fatal(w.Body.String())
+	}
+}
+
+func TestError(t *testing.T) {
+	r := newEngine()
+	req := httptest.NewRequest(http.MethodPost, "/params", strings.NewReader(`{"name":"0123456789"}`))
+	w, resp := do(t, r, req)
+	if resp.Code != CodeParams || resp.Msg != xerr.ParamsErr.Error() || resp.RequestID == "" {
+		t.Fatal(w.Body.String())
+	}
+}
+
+func TestErrorUnregistered(t *testing.T) {
+	r := newEngine()
+	w, resp := do(t, r, httptest.NewRequest(http.MethodGet, "/db", nil))
+	if resp.Code != CodeInternal || resp.Msg != InternalMsg || strings.Contains(w.Body.String(), "shop.user") {
+		t.Fatal(w.Body.String())
+	}
+	w, resp = do(t, r, httptest.NewRequest(http.MethodGet, "/db-registered", nil))
+	if resp.Code != CodeDB || resp.Msg != xerr.DBErr.Error() {
+		t.Fatal(w.Body.String())
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	r := newEngine()
+	w, resp := do(t, r, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if w.Code != http.StatusInternalServerError || resp.Code != CodeInternal {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}